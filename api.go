@@ -1,10 +1,12 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"net/http"
 	"runtime"
+	"sort"
 	"strings"
 
 	"github.com/hoisie/web"
@@ -12,120 +14,825 @@ import (
 	"github.com/nfleet/via/geotypes"
 )
 
-var allowedSpeeds = []int{40, 60, 80, 100, 120}
+type Result struct {
+	Progress string `json:"progress"`
+	// Matrix is a map[string][]int or a [][]int, depending on the format.
+	Matrix interface{} `json:"matrix"`
+	// Travel times in seconds and path lengths in meters, in the format of
+	// Matrix, if requested.
+	Durations      interface{}   `json:"durations,omitempty"`
+	Distances      interface{}   `json:"distances,omitempty"`
+	SpeedProfile   SpeedProfile  `json:"speed_profile"`
+	SnappedSources []SnappedNode `json:"snapped_sources,omitempty"`
+	SnappedTargets []SnappedNode `json:"snapped_targets,omitempty"`
 
-func contains(a int, list []int) bool {
-	for _, b := range list {
-		if b == a {
-			return true
-		}
-	}
-	return false
+	// The matrices before formatting, for the binary media types.
+	weights, durations, distances *Matrix
+	// What was computed, so that the matrix can be updated; see PostMatrixUpdate.
+	country          string
+	sources, targets []int
 }
 
-type Result struct {
-	Progress     string           `json:"progress"`
-	Matrix       map[string][]int `json:"matrix"`
-	SpeedProfile int              `json:"speed_profile"`
+// Returns the result of a computed matrix in format, with the durations if
+// requested and the distances if not nil.
+func (server *Server) newResult(format string, sp SpeedProfile, weights Matrix, durations bool, distances *Matrix) *Result {
+	result := &Result{
+		Progress:     JobComplete,
+		SpeedProfile: sp,
+		weights:      &weights,
+		distances:    distances,
+	}
+	result.Matrix, _ = weights.Formatted(format)
+	if durations {
+		d := server.Via.Durations(weights)
+		result.durations = &d
+		result.Durations, _ = d.Formatted(format)
+	}
+	if distances != nil {
+		result.Distances, _ = distances.Formatted(format)
+	}
+	return result
 }
 
 // Starts a computation, validates the matrix in POST.
-// If matrix data is missing, returns 400 Bad Request.
-// If on the other hand matrix is data is not missing,
-// but makes no sense, it returns 422 Unprocessable Entity.
+// The nodes are given either as a single matrix list, which yields a square
+// matrix, or as separate sources and targets lists, which yield a matrix with
+// a row for every source and a column for every target. Targets default to
+// the sources when omitted. Every list holds either node IDs or
+// [latitude, longitude] pairs; coordinates are snapped to the nearest node
+// and the snapped nodes are returned with the result.
+// The format selects how the matrix is returned: "keyed", the default, gives
+// an object from the row number, as a string, to the row, and "dense" gives
+// a list of rows in source order.
+// With durations set, the result also holds the travel times in seconds, and
+// with distances set the shortest path lengths in meters, both in the same
+// format as the matrix. Pairs without a route are 4294967295 in every matrix.
+// Distances take a query per pair and are much slower than the rest.
+// The response is JSON unless the Accept header asks for the binary format,
+// MessagePack or NDJSON, see encodeResult. NDJSON is streamed, every row is
+// written as soon as it is computed.
+// The parallel flag overrides the server's -par setting for this request.
+// With async set, the computation is queued and the response is 202 Accepted
+// with the job location in the Location header; see GetMatrix.
+// Invalid requests are answered with a request error, see the error package.
 func (server *Server) PostMatrix(ctx *web.Context) {
 	defer runtime.GC()
 
+	key, e := server.authenticate(ctx)
+	if e != nil {
+		e.WriteTo(ctx.ResponseWriter)
+		return
+	}
+
 	// Parse params
 	var paramBlob struct {
-		Matrix       []int   `json:"matrix"`
-		Country      string  `json:"country"`
-		SpeedProfile float64 `json:"speed_profile"`
+		Matrix       NodeList     `json:"matrix"`
+		Sources      NodeList     `json:"sources"`
+		Targets      NodeList     `json:"targets"`
+		Country      string       `json:"country"`
+		SpeedProfile SpeedProfile `json:"speed_profile"`
+		matrixOutput
 	}
 	if err := json.NewDecoder(ctx.Request.Body).Decode(&paramBlob); err != nil {
-		ctx.Abort(400, err.Error())
+		viaErr.NewRequestError(viaErr.ReqErrInvalidJSON, err.Error()).WriteTo(ctx.ResponseWriter)
 		return
 	}
 
-	data := paramBlob.Matrix
+	sources, targets := paramBlob.Sources, paramBlob.Targets
+	if sources.Len() == 0 {
+		sources = paramBlob.Matrix
+	}
 	country := strings.ToLower(paramBlob.Country)
-	sp := int(paramBlob.SpeedProfile)
-
-	ok := len(data) > 0 && country != "" && sp > 0
-	if ok {
-		// Sanitize speed profile.
-		if !contains(sp, allowedSpeeds) {
-			msg := fmt.Sprintf("speed profile '%d' makes no sense, must be one of %s", sp, fmt.Sprint(allowedSpeeds))
-			ctx.Abort(422, msg)
-			return
+	sp := paramBlob.SpeedProfile
+
+	media, parallel, e := server.parseOutput(ctx, &paramBlob.matrixOutput)
+	if e != nil {
+		e.WriteTo(ctx.ResponseWriter)
+		return
+	}
+	if e := server.checkProfile(country, sp); e != nil {
+		e.WriteTo(ctx.ResponseWriter)
+		return
+	}
+	labelRequest(ctx.Request, country, sp)
+	if sources.Len() == 0 {
+		viaErr.NewRequestError(viaErr.ReqErrEmptyNodeList, "no matrix or sources given").WriteTo(ctx.ResponseWriter)
+		return
+	}
+	columns := targets.Len()
+	if columns == 0 {
+		columns = sources.Len()
+	}
+	if e := server.checkCells(sources.Len(), columns); e != nil {
+		e.WriteTo(ctx.ResponseWriter)
+		return
+	}
+	// Sanitize coordinates.
+	if e := server.checkCoordinates("sources", sources.Coords, country); e != nil {
+		e.WriteTo(ctx.ResponseWriter)
+		return
+	}
+	if e := server.checkCoordinates("targets", targets.Coords, country); e != nil {
+		e.WriteTo(ctx.ResponseWriter)
+		return
+	}
+
+	sourceIDs, snappedSources, err := server.Via.ResolveNodes(sources, country)
+	if err != nil {
+		viaErr.NewError(viaErr.ErrNodeCoordinates, err.Error()).WriteTo(ctx.ResponseWriter)
+		return
+	}
+	targetIDs, snappedTargets, err := server.Via.ResolveNodes(targets, country)
+	if err != nil {
+		viaErr.NewError(viaErr.ErrNodeCoordinates, err.Error()).WriteTo(ctx.ResponseWriter)
+		return
+	}
+
+	// Sanitize node IDs, the CH library doesn't check them. The node count
+	// comes from the graph file, so async requests don't wait for the graph
+	// to load.
+	graph, err := server.Via.Graphs.FileInfo(country, sp)
+	if err != nil {
+		chError(err, viaErr.ErrContractionHierarchies).WriteTo(ctx.ResponseWriter)
+		return
+	}
+	if e := checkNodes(graph, "sources", sourceIDs); e != nil {
+		e.WriteTo(ctx.ResponseWriter)
+		return
+	}
+	if e := checkNodes(graph, "targets", targetIDs); e != nil {
+		e.WriteTo(ctx.ResponseWriter)
+		return
+	}
+
+	if e := server.admitMatrix(key, country, sp, sourceIDs, targetIDs, paramBlob.Distances); e != nil {
+		e.WriteTo(ctx.ResponseWriter)
+		return
+	}
+
+	compute := func() (*Result, *viaErr.Error) {
+		matrix, err := server.Via.ComputeMatrix(sourceIDs, targetIDs, country, sp, parallel)
+		if err != nil {
+			return nil, chError(err, viaErr.ErrMatrixComputation)
 		}
-		// Sanitize country.
-		if _, ok := server.AllowedCountries[country]; !ok {
-			countries := ""
-			for k := range server.AllowedCountries {
-				countries += k + " "
+		var distances *Matrix
+		if paramBlob.Distances {
+			d, err := server.Via.ComputeDistances(sourceIDs, targetIDs, country, sp, parallel)
+			if err != nil {
+				return nil, chError(err, viaErr.ErrNodeCoordinates)
 			}
-			ctx.Abort(422, "country "+country+" not allowed, must be one of: "+countries)
-			return
+			distances = &d
 		}
 
-		matrix, err := server.Via.ComputeMatrix(data, country, sp)
-		if err != nil {
-			viaErr.NewError(viaErr.ErrMatrixComputation, err.Error()).WriteTo(ctx.ResponseWriter)
-			return
+		result := server.newResult(paramBlob.Format, sp, matrix, paramBlob.Durations, distances)
+		result.SnappedSources, result.SnappedTargets = snappedSources, snappedTargets
+		result.country, result.sources, result.targets = country, sourceIDs, targetIDs
+		if len(targetIDs) == 0 {
+			result.targets = sourceIDs
+		}
+		return result, nil
+	}
+
+	if media == MediaNDJSON && !paramBlob.Async {
+		header := ndjsonHeader{sources.Len(), columns, sp, snappedSources, snappedTargets}
+		server.streamMatrix(ctx, header, sourceIDs, targetIDs, country, sp, paramBlob.Durations, paramBlob.Distances)
+		return
+	}
+
+	server.respond(ctx, compute, paramBlob.Async, media)
+}
+
+// The parameters of PostMatrix and PostMatrixUpdate that select how the
+// matrix is computed and returned.
+type matrixOutput struct {
+	Format    string `json:"format"`
+	Durations bool   `json:"durations"`
+	Distances bool   `json:"distances"`
+	Async     bool   `json:"async"`
+	Parallel  *bool  `json:"parallel"`
+}
+
+// Defaults and validates the format of out, and picks the media type of the
+// response from the Accept header. Returns the media type and whether to
+// compute in parallel.
+func (server *Server) parseOutput(ctx *web.Context, out *matrixOutput) (string, bool, *viaErr.Error) {
+	if out.Format == "" {
+		out.Format = FormatKeyed
+	}
+	media := negotiateMedia(ctx.Request.Header.Get("Accept"))
+	if media == "" {
+		return "", false, notAcceptable()
+	}
+	if _, ok := (Matrix{}).Formatted(out.Format); !ok {
+		msg := fmt.Sprintf("format '%s' makes no sense, must be %s or %s", out.Format, FormatKeyed, FormatDense)
+		return "", false, viaErr.NewRequestError(viaErr.ReqErrBadFormat, msg)
+	}
+
+	parallel := server.Via.Parallel
+	if out.Parallel != nil {
+		parallel = *out.Parallel
+	}
+	return media, parallel, nil
+}
+
+// Checks that a rows x cols matrix is within MaxMatrixCells.
+func (server *Server) checkCells(rows, cols int) *viaErr.Error {
+	if cells := rows * cols; server.MaxMatrixCells > 0 && cells > server.MaxMatrixCells {
+		msg := fmt.Sprintf("matrix of %d cells exceeds the limit of %d", cells, server.MaxMatrixCells)
+		return viaErr.NewRequestError(viaErr.ReqErrTooLarge, msg)
+	}
+	return nil
+}
+
+// Charges the matrix from sources to targets, or to sources if targets is
+// empty, to the API key like allow, cached results at their cached rate, and
+// counts it in the metrics.
+func (server *Server) admitMatrix(key, country string, sp SpeedProfile, sources, targets []int, distances bool) *viaErr.Error {
+	cols := len(targets)
+	if cols == 0 {
+		cols = len(sources)
+	}
+	cached := server.Via.isCached(matrixKey("matrix", country, sp, sources, targets)) &&
+		(!distances || server.Via.isCached(matrixKey("distances", country, sp, sources, targets)))
+	if e := server.allow(key, country, len(sources), cols, cached); e != nil {
+		return e
+	}
+	server.Metrics.ObserveMatrix(country, sp, len(sources), cols)
+	return nil
+}
+
+// Runs compute and writes its result as media, or with async set, queues it
+// and answers 202 Accepted with the job location; see GetMatrix.
+func (server *Server) respond(ctx *web.Context, compute func() (*Result, *viaErr.Error), async bool, media string) {
+	if async {
+		job := server.Via.SubmitMatrix(compute)
+		ctx.SetHeader("Location", "/matrix/"+job.ID, true)
+		ctx.ContentType("json")
+		ctx.WriteHeader(202)
+		json.NewEncoder(ctx.ResponseWriter).Encode(job)
+		return
+	}
+
+	result, resErr := compute()
+	if resErr != nil {
+		resErr.WriteTo(ctx.ResponseWriter)
+		return
+	}
+	writeResult(ctx, result, media)
+}
+
+// Streams the matrix as NDJSON, a line per row as soon as the row is computed.
+// The matrix is computed sequentially, whatever the parallel setting.
+func (server *Server) streamMatrix(ctx *web.Context, header ndjsonHeader, sources, targets []int, country string, sp SpeedProfile, durations, distances bool) {
+	if len(targets) == 0 {
+		targets = sources
+	}
+
+	ctx.SetHeader("Content-Type", MediaNDJSON, true)
+	ctx.WriteHeader(200)
+
+	flusher, _ := ctx.ResponseWriter.(http.Flusher)
+	enc := json.NewEncoder(ctx.ResponseWriter)
+	if err := enc.Encode(header); err != nil {
+		return
+	}
+
+	err := server.Via.StreamMatrix(sources, targets, country, sp, distances, func(i int, weights, rowDistances []uint32) error {
+		row := ndjsonRow{Row: i, Weights: weights, Distances: rowDistances}
+		if durations {
+			row.Durations = server.Via.Durations(Matrix{1, len(weights), weights}).Weights
+		}
+		if err := enc.Encode(row); err != nil {
+			return err
 		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		enc.Encode(ndjsonError{chError(err, viaErr.ErrMatrixComputation)})
+	}
+}
+
+// Writes result encoded as media.
+func writeResult(ctx *web.Context, result *Result, media string) {
+	res, err := encodeResult(result, media)
+	if err != nil {
+		viaErr.NewError(viaErr.ErrEncoding, err.Error()).WriteTo(ctx.ResponseWriter)
+		return
+	}
+	ctx.SetHeader("Content-Type", media, true)
+	ctx.WriteHeader(200)
+	ctx.Write(res)
+}
+
+func notAcceptable() *viaErr.Error {
+	msg := fmt.Sprintf("results are available as %s", strings.Join(resultMedia, ", "))
+	return viaErr.NewRequestError(viaErr.ReqErrNotAcceptable, msg)
+}
 
-		result := Result{
-			Progress:     "complete",
-			Matrix:       matrix,
-			SpeedProfile: sp,
+// Returns the API key of the request, or "" if the server has no keys.
+func (server *Server) authenticate(ctx *web.Context) (string, *viaErr.Error) {
+	if server.Keys == nil {
+		return "", nil
+	}
+	key, e := server.Keys.Authenticate(ctx.Request.Header.Get("Authorization"))
+	if e != nil {
+		ctx.SetHeader("WWW-Authenticate", "Bearer", true)
+	}
+	return key, e
+}
+
+// Checks that the API key may compute a rows x cols matrix in country and
+// charges it to the key's quotas. Call it once the request is valid, so that
+// rejected requests cost nothing.
+func (server *Server) allow(key, country string, rows, cols int, cached bool) *viaErr.Error {
+	if server.Keys == nil {
+		return nil
+	}
+	return server.Keys.Allow(key, country, rows, cols, cached)
+}
+
+// Validates the country and speed profile of a request.
+func (server *Server) checkProfile(country string, sp SpeedProfile) *viaErr.Error {
+	if _, ok := server.AllowedCountries[country]; !ok {
+		countries := make([]string, 0, len(server.AllowedCountries))
+		for k := range server.AllowedCountries {
+			countries = append(countries, k)
 		}
+		sort.Strings(countries)
+		msg := fmt.Sprintf("country '%s' not allowed, must be one of %v", country, countries)
+		return viaErr.NewRequestError(viaErr.ReqErrUnknownCountry, msg)
+	}
+	profile, ok := server.Profiles.Get(sp)
+	if !ok {
+		msg := fmt.Sprintf("speed profile '%s' makes no sense, must be one of %v", sp, server.Profiles.Names())
+		return viaErr.NewRequestError(viaErr.ReqErrBadSpeedProfile, msg)
+	}
+	if !containsString(profile.Countries, country) {
+		msg := fmt.Sprintf("speed profile '%s' has no graph for %s, only for %v", sp, country, profile.Countries)
+		return viaErr.NewRequestError(viaErr.ReqErrBadSpeedProfile, msg)
+	}
+	return nil
+}
+
+// Checks that ids are nodes of graph. The error names the offending IDs and
+// points at them in the given list.
+func checkNodes(graph GraphFileInfo, list string, ids []int) *viaErr.Error {
+	invalid := graph.InvalidNodes(ids)
+	if len(invalid) == 0 {
+		return nil
+	}
+	bad := make([]int, len(invalid))
+	for i, index := range invalid {
+		bad[i] = ids[index]
+	}
+	msg := fmt.Sprintf("%s %v are not nodes of %s-%s, which has %d nodes", list, bad, graph.Country, graph.SpeedProfile, graph.Nodes)
+	return viaErr.NewIndexedRequestError(viaErr.ReqErrNodeOutOfRange, msg, invalid)
+}
+
+// Reports the progress of an asynchronous matrix job. Queued and running jobs
+// return 200 OK with the progress, failed jobs return the error they failed with
+// and complete jobs redirect with 303 See Other to the result.
+func (server *Server) GetMatrix(ctx *web.Context, id string) {
+	job, ok := server.Via.Jobs.Get(id)
+	if !ok {
+		viaErr.NewRequestError(viaErr.ReqErrMatrixNotFound, "no matrix with id "+id).WriteTo(ctx.ResponseWriter)
+		return
+	}
 
-		ctx.WriteHeader(200)
+	switch job.Progress {
+	case JobFailed:
+		job.Err.WriteTo(ctx.ResponseWriter)
+	case JobComplete:
+		ctx.Redirect(303, "/matrix/"+job.ID+"/result")
+	default:
 		ctx.ContentType("json")
-		if err := json.NewEncoder(ctx.ResponseWriter).Encode(result); err != nil {
-			viaErr.NewError(viaErr.ErrMatrixComputation, "Failed to encode results to response writer.").WriteTo(ctx.ResponseWriter)
+		json.NewEncoder(ctx.ResponseWriter).Encode(job)
+	}
+}
+
+// Returns the result of a complete asynchronous matrix job, in the media type
+// the Accept header asks for like PostMatrix.
+func (server *Server) GetMatrixResult(ctx *web.Context, id string) {
+	job, ok := server.Via.Jobs.Get(id)
+	if !ok || job.Progress != JobComplete {
+		viaErr.NewRequestError(viaErr.ReqErrMatrixNotFound, "no complete matrix with id "+id).WriteTo(ctx.ResponseWriter)
+		return
+	}
+
+	media := negotiateMedia(ctx.Request.Header.Get("Accept"))
+	if media == "" {
+		notAcceptable().WriteTo(ctx.ResponseWriter)
+		return
+	}
+	writeResult(ctx, job.Result, media)
+}
+
+// Computes a new matrix from a complete asynchronous matrix job by adding and
+// removing nodes, so that only the new rows and columns are computed.
+// Add appends nodes to both the sources and the targets, after the kept ones,
+// and remove takes the nodes at the given indices out of both; add_sources,
+// add_targets, remove_sources and remove_targets change just one of them. The
+// added nodes are node IDs or coordinates like in PostMatrix, and the indices
+// refer to the sources and targets of the previous matrix. The country and
+// speed profile are those of the previous matrix. Distances are reused only if
+// the previous matrix has them; snapped nodes are returned only if every node
+// was given as coordinates.
+// The other parameters and the response are those of PostMatrix; with async
+// set, the new job can be updated in turn.
+func (server *Server) PostMatrixUpdate(ctx *web.Context, id string) {
+	key, e := server.authenticate(ctx)
+	if e != nil {
+		e.WriteTo(ctx.ResponseWriter)
+		return
+	}
+
+	job, ok := server.Via.Jobs.Get(id)
+	if !ok || job.Progress != JobComplete {
+		viaErr.NewRequestError(viaErr.ReqErrMatrixNotFound, "no complete matrix with id "+id).WriteTo(ctx.ResponseWriter)
+		return
+	}
+	prev := job.Result
+
+	var params struct {
+		Add           NodeList `json:"add"`
+		Remove        []int    `json:"remove"`
+		AddSources    NodeList `json:"add_sources"`
+		AddTargets    NodeList `json:"add_targets"`
+		RemoveSources []int    `json:"remove_sources"`
+		RemoveTargets []int    `json:"remove_targets"`
+		matrixOutput
+	}
+	if err := json.NewDecoder(ctx.Request.Body).Decode(&params); err != nil {
+		viaErr.NewRequestError(viaErr.ReqErrInvalidJSON, err.Error()).WriteTo(ctx.ResponseWriter)
+		return
+	}
+
+	country, sp := prev.country, prev.SpeedProfile
+	labelRequest(ctx.Request, country, sp)
+
+	media, parallel, e := server.parseOutput(ctx, &params.matrixOutput)
+	if e != nil {
+		e.WriteTo(ctx.ResponseWriter)
+		return
+	}
+
+	keptRows, e := keptIndices("sources", len(prev.sources), params.Remove, params.RemoveSources)
+	if e != nil {
+		e.WriteTo(ctx.ResponseWriter)
+		return
+	}
+	keptCols, e := keptIndices("targets", len(prev.targets), params.Remove, params.RemoveTargets)
+	if e != nil {
+		e.WriteTo(ctx.ResponseWriter)
+		return
+	}
+
+	rows := len(keptRows) + params.Add.Len() + params.AddSources.Len()
+	cols := len(keptCols) + params.Add.Len() + params.AddTargets.Len()
+	if rows == 0 || cols == 0 {
+		viaErr.NewRequestError(viaErr.ReqErrEmptyNodeList, "update leaves no sources or targets").WriteTo(ctx.ResponseWriter)
+		return
+	}
+	if e := server.checkCells(rows, cols); e != nil {
+		e.WriteTo(ctx.ResponseWriter)
+		return
+	}
+	graph, err := server.Via.Graphs.FileInfo(country, sp)
+	if err != nil {
+		chError(err, viaErr.ErrContractionHierarchies).WriteTo(ctx.ResponseWriter)
+		return
+	}
+	lists := []struct {
+		name  string
+		nodes NodeList
+		ids   []int
+		snap  []SnappedNode
+	}{{name: "add", nodes: params.Add}, {name: "add_sources", nodes: params.AddSources}, {name: "add_targets", nodes: params.AddTargets}}
+	for i := range lists {
+		l := &lists[i]
+		if e := server.checkCoordinates(l.name, l.nodes.Coords, country); e != nil {
+			e.WriteTo(ctx.ResponseWriter)
 			return
 		}
+		if l.ids, l.snap, err = server.Via.ResolveNodes(l.nodes, country); err != nil {
+			viaErr.NewError(viaErr.ErrNodeCoordinates, err.Error()).WriteTo(ctx.ResponseWriter)
+			return
+		}
+		if e := checkNodes(graph, l.name, l.ids); e != nil {
+			e.WriteTo(ctx.ResponseWriter)
+			return
+		}
+	}
+	add, addSources, addTargets := lists[0], lists[1], lists[2]
 
-	} else {
-		body, _ := ioutil.ReadAll(ctx.Request.Body)
-		ctx.Abort(400, "Missing or invalid matrix data, speed profile, or country. You sent: "+string(body))
+	sources := make([]int, 0, rows)
+	for _, i := range keptRows {
+		sources = append(sources, prev.sources[i])
+	}
+	sources = append(append(sources, add.ids...), addSources.ids...)
+	targets := make([]int, 0, cols)
+	for _, i := range keptCols {
+		targets = append(targets, prev.targets[i])
+	}
+	targets = append(append(targets, add.ids...), addTargets.ids...)
+
+	if e := server.admitMatrix(key, country, sp, sources, targets, params.Distances); e != nil {
+		e.WriteTo(ctx.ResponseWriter)
 		return
 	}
+
+	byID := func(l NodeList) bool { return l.Coords == nil && len(l.IDs) > 0 }
+	var snappedSources, snappedTargets []SnappedNode
+	if prev.SnappedSources != nil && !byID(params.Add) && !byID(params.AddSources) {
+		for _, i := range keptRows {
+			snappedSources = append(snappedSources, prev.SnappedSources[i])
+		}
+		snappedSources = append(append(snappedSources, add.snap...), addSources.snap...)
+	}
+	if prev.SnappedTargets != nil && !byID(params.Add) && !byID(params.AddTargets) {
+		for _, i := range keptCols {
+			snappedTargets = append(snappedTargets, prev.SnappedTargets[i])
+		}
+		snappedTargets = append(append(snappedTargets, add.snap...), addTargets.snap...)
+	}
+
+	compute := func() (*Result, *viaErr.Error) {
+		matrix, err := server.Via.ExtendMatrix(*prev.weights, keptRows, keptCols, sources, targets, country, sp, parallel)
+		if err != nil {
+			return nil, chError(err, viaErr.ErrMatrixComputation)
+		}
+		var distances *Matrix
+		if params.Distances {
+			var d Matrix
+			if prev.distances != nil {
+				d, err = server.Via.ExtendDistances(*prev.distances, keptRows, keptCols, sources, targets, country, sp, parallel)
+			} else {
+				d, err = server.Via.ComputeDistances(sources, targets, country, sp, parallel)
+			}
+			if err != nil {
+				return nil, chError(err, viaErr.ErrNodeCoordinates)
+			}
+			distances = &d
+		}
+
+		result := server.newResult(params.Format, sp, matrix, params.Durations, distances)
+		result.SnappedSources, result.SnappedTargets = snappedSources, snappedTargets
+		result.country, result.sources, result.targets = country, sources, targets
+		return result, nil
+	}
+
+	server.respond(ctx, compute, params.Async, media)
+}
+
+// Returns the indices below n that are in neither of the removed lists, in
+// order. Removed indices must be below n; the error points at the others in
+// the named list.
+func keptIndices(list string, n int, removed ...[]int) ([]int, *viaErr.Error) {
+	drop := make([]bool, n)
+	for _, indices := range removed {
+		var bad []int
+		for i, index := range indices {
+			if index < 0 || index >= n {
+				bad = append(bad, i)
+				continue
+			}
+			drop[index] = true
+		}
+		if bad != nil {
+			msg := fmt.Sprintf("the previous matrix has %d %s, can't remove %v", n, list, indices)
+			return nil, viaErr.NewIndexedRequestError(viaErr.ReqErrNodeOutOfRange, msg, bad)
+		}
+	}
+
+	kept := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		if !drop[i] {
+			kept = append(kept, i)
+		}
+	}
+	return kept, nil
+}
+
+// ServerStatus tells whether the server is ready to serve requests.
+type ServerStatus struct {
+	Status string `json:"status"` // StatusOK or StatusUnavailable
+	// The state of the geo database. Only resolving addresses and
+	// coordinates needs it, so it doesn't keep the server from being ready.
+	GeoDB string `json:"geodb"`
+	// What keeps the server from being ready.
+	Errors []string `json:"errors,omitempty"`
+	// The loaded graphs, the memory they use and the Go heap.
+	Graphs     []Graph `json:"graphs"`
+	GraphBytes uint64  `json:"graph_bytes"`
+	HeapBytes  uint64  `json:"heap_bytes"`
+}
+
+// Status values of ServerStatus.
+const (
+	StatusOK          = "OK"
+	StatusUnavailable = "UNAVAILABLE"
+)
+
+// Checks that the graph files of the speed profiles found at start-up are
+// still readable and that every allowed country has one, and reports whether
+// the geo database answers. Probes don't scan the data directory, and graphs
+// that later scans no longer find still count as missing.
+func (server *Server) Readiness() ServerStatus {
+	status := ServerStatus{
+		Status: StatusOK,
+		GeoDB:  "OK",
+		Graphs: server.Via.Graphs.Loaded(),
+	}
+	if err := server.Geo.QueryStatus(); err != nil {
+		status.GeoDB = err.Error()
+	}
+
+	profiles := server.Profiles.Required()
+	if len(profiles) == 0 {
+		status.Errors = append(status.Errors, "no speed profiles")
+	}
+	served := make(map[string]bool)
+	for _, p := range profiles {
+		if len(p.Countries) == 0 {
+			status.Errors = append(status.Errors, fmt.Sprintf("speed profile %s has no graph files", p.Name))
+		}
+		for _, country := range p.Countries {
+			if _, err := server.Via.Graphs.FileInfo(country, p.Name); err != nil {
+				status.Errors = append(status.Errors, fmt.Sprintf("graph %s-%s: %s", country, p.Name, err.Error()))
+				continue
+			}
+			served[country] = true
+		}
+	}
+	countries := make([]string, 0, len(server.AllowedCountries))
+	for country := range server.AllowedCountries {
+		countries = append(countries, country)
+	}
+	sort.Strings(countries)
+	for _, country := range countries {
+		if len(profiles) > 0 && !served[country] {
+			status.Errors = append(status.Errors, "no graph for "+country)
+		}
+	}
+
+	for _, g := range status.Graphs {
+		status.GraphBytes += g.MemoryBytes
+	}
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	status.HeapBytes = memStats.HeapAlloc
+
+	if len(status.Errors) > 0 {
+		status.Status = StatusUnavailable
+	}
+	return status
 }
 
+// Reports the readiness of the server, see Readiness. A server that isn't
+// ready answers 503 Service Unavailable, so that load balancers pass it by.
 func (server *Server) GetServerStatus(ctx *web.Context) string {
-	return "OK"
+	status := server.Readiness()
+	res, err := json.Marshal(status)
+	if err != nil {
+		viaErr.NewError(viaErr.ErrEncoding, err.Error()).WriteTo(ctx.ResponseWriter)
+		return ""
+	}
+
+	ctx.ContentType("application/json")
+	if status.Status != StatusOK {
+		ctx.WriteHeader(503)
+	}
+	return string(res)
+}
+
+// Answers as long as the server runs, whether it is ready or not.
+func GetLiveness(ctx *web.Context) string {
+	ctx.ContentType("application/json")
+	return `{"status":"OK"}`
+}
+
+// Serves the metrics in the Prometheus text format.
+func (server *Server) GetMetrics(ctx *web.Context) string {
+	var buf bytes.Buffer
+	server.Metrics.WriteTo(&buf, server.Via)
+	ctx.ContentType("text/plain; version=0.0.4")
+	return buf.String()
+}
+
+// Lists the speed profiles and their graph files in the data directory, with
+// the size of every graph and whether it is loaded.
+func (server *Server) GetProfiles(ctx *web.Context) string {
+	if err := server.Profiles.Scan(); err != nil {
+		viaErr.NewError(viaErr.ErrContractionHierarchies, err.Error()).WriteTo(ctx.ResponseWriter)
+		return ""
+	}
+	profiles := server.Profiles.List()
+
+	res, err := json.Marshal(struct {
+		Profiles []Profile       `json:"profiles"`
+		Graphs   []GraphFileInfo `json:"graphs"`
+	}{profiles, server.Via.Graphs.Files(profiles)})
+	if err != nil {
+		viaErr.NewError(viaErr.ErrEncoding, err.Error()).WriteTo(ctx.ResponseWriter)
+		return ""
+	}
+
+	ctx.ContentType("application/json")
+	return string(res)
 }
 
+// Calculates the shortest path for every source/target pair. The paths are
+// returned as node IDs, or as node coordinates if Coordinates is set.
+// Pairs without a route get an empty path of length 4294967295, or with
+// Strict set, fail the request with their indices.
 func (server *Server) PostPaths(ctx *web.Context) string {
+	key, e := server.authenticate(ctx)
+	if e != nil {
+		e.WriteTo(ctx.ResponseWriter)
+		return ""
+	}
+
 	var input struct {
 		Paths        []geotypes.NodeEdge
 		Country      string
-		SpeedProfile int
+		SpeedProfile SpeedProfile
+		Coordinates  bool
+		Strict       bool
 	}
 
-	var (
-		computed []geotypes.Path
-	)
-
 	if err := json.NewDecoder(ctx.Request.Body).Decode(&input); err != nil {
-		content, _ := ioutil.ReadAll(ctx.Request.Body)
-		ctx.Abort(400, "Couldn't parse JSON: "+err.Error()+" in '"+string(content)+"'")
+		viaErr.NewRequestError(viaErr.ReqErrInvalidJSON, err.Error()).WriteTo(ctx.ResponseWriter)
+		return ""
+	}
+
+	country := strings.ToLower(input.Country)
+	if e := server.checkProfile(country, input.SpeedProfile); e != nil {
+		e.WriteTo(ctx.ResponseWriter)
+		return ""
+	}
+	labelRequest(ctx.Request, country, input.SpeedProfile)
+	if len(input.Paths) == 0 {
+		viaErr.NewRequestError(viaErr.ReqErrEmptyNodeList, "no paths given").WriteTo(ctx.ResponseWriter)
+		return ""
+	}
+	if server.MaxPaths > 0 && len(input.Paths) > server.MaxPaths {
+		msg := fmt.Sprintf("%d paths exceed the limit of %d", len(input.Paths), server.MaxPaths)
+		viaErr.NewRequestError(viaErr.ReqErrTooLarge, msg).WriteTo(ctx.ResponseWriter)
+		return ""
+	}
+	// Sanitize node IDs, the CH library doesn't check them.
+	graph, err := server.Via.Graphs.FileInfo(country, input.SpeedProfile)
+	if err != nil {
+		chError(err, viaErr.ErrContractionHierarchies).WriteTo(ctx.ResponseWriter)
+		return ""
+	}
+	ends := make([]int, 0, 2*len(input.Paths))
+	for _, p := range input.Paths {
+		ends = append(ends, p.Source, p.Target)
+	}
+	if e := checkNodes(graph, "nodes", ends); e != nil {
+		// point at the pairs rather than at their ends
+		var pairs []int
+		for _, i := range e.Indices {
+			if len(pairs) == 0 || pairs[len(pairs)-1] != i/2 {
+				pairs = append(pairs, i/2)
+			}
+		}
+		e.Indices = pairs
+		e.WriteTo(ctx.ResponseWriter)
+		return ""
+	}
+	cached := server.Via.isCached(pathsKey(input.Paths, country, input.SpeedProfile))
+	if e := server.allow(key, country, len(input.Paths), 1, cached); e != nil {
+		e.WriteTo(ctx.ResponseWriter)
+		return ""
+	}
+
+	paths, err := server.Via.CalculatePaths(input.Paths, country, input.SpeedProfile)
+	if err != nil {
+		chError(err, viaErr.ErrPathComputation).WriteTo(ctx.ResponseWriter)
+		return ""
+	}
+	if unreachable := unreachablePaths(paths); input.Strict && len(unreachable) > 0 {
+		viaErr.NewIndexedRequestError(viaErr.ReqErrUnreachable, "no route for some pairs", unreachable).WriteTo(ctx.ResponseWriter)
 		return ""
-	} else {
-		var err error
-		computed, err = server.Via.CalculatePaths(input.Paths, input.Country, input.SpeedProfile)
+	}
+
+	var computed interface{} = paths
+	if input.Coordinates {
+		computed, err = server.Via.PathCoordinates(paths, country)
 		if err != nil {
-			ctx.Abort(422, "Couldn't resolve addresses: "+err.Error())
+			viaErr.NewError(viaErr.ErrNodeCoordinates, err.Error()).WriteTo(ctx.ResponseWriter)
 			return ""
 		}
 	}
 
 	res, err := json.Marshal(computed)
 	if err != nil {
-		ctx.Abort(500, "Couldn't serialize paths: "+err.Error())
+		viaErr.NewError(viaErr.ErrEncoding, err.Error()).WriteTo(ctx.ResponseWriter)
 		return ""
 	}
 
@@ -133,3 +840,68 @@ func (server *Server) PostPaths(ctx *web.Context) string {
 	ctx.ContentType("application/json")
 	return string(res)
 }
+
+// Fills in the coordinates of locations that have none by looking up their
+// street and city.
+func (server *Server) PostResolve(ctx *web.Context) string {
+	var locations []geotypes.Location
+	if err := json.NewDecoder(ctx.Request.Body).Decode(&locations); err != nil {
+		viaErr.NewRequestError(viaErr.ReqErrInvalidJSON, err.Error()).WriteTo(ctx.ResponseWriter)
+		return ""
+	}
+	if len(locations) == 0 {
+		viaErr.NewRequestError(viaErr.ReqErrEmptyNodeList, "no locations given").WriteTo(ctx.ResponseWriter)
+		return ""
+	}
+
+	// Sanitize coordinates.
+	var outside []int
+	for i, loc := range locations {
+		if !hasCoordinate(loc) {
+			continue
+		}
+		coord := geotypes.Coord{loc.Coordinate.Latitude, loc.Coordinate.Longitude}
+		if ok, _ := server.check_coordinate_sanity([]geotypes.Coord{coord}, strings.ToLower(loc.Address.Country)); !ok {
+			outside = append(outside, i)
+		}
+	}
+	if len(outside) > 0 {
+		viaErr.NewIndexedRequestError(viaErr.ReqErrCoordinatesOutOfBounds, "coordinates outside of their country", outside).WriteTo(ctx.ResponseWriter)
+		return ""
+	}
+
+	var notFound []int
+	for i, loc := range locations {
+		if hasCoordinate(loc) {
+			continue
+		}
+
+		matches, err := server.Geo.QueryFuzzyAddress(loc.Address, 1)
+		if err != nil {
+			viaErr.NewError(viaErr.ErrGeoDB, err.Error()).WriteTo(ctx.ResponseWriter)
+			return ""
+		}
+		if len(matches) == 0 {
+			notFound = append(notFound, i)
+			continue
+		}
+		locations[i] = matches[0]
+	}
+	if len(notFound) > 0 {
+		viaErr.NewIndexedRequestError(viaErr.ReqErrAddressNotFound, "no match for some addresses", notFound).WriteTo(ctx.ResponseWriter)
+		return ""
+	}
+
+	res, err := json.Marshal(locations)
+	if err != nil {
+		viaErr.NewError(viaErr.ErrEncoding, err.Error()).WriteTo(ctx.ResponseWriter)
+		return ""
+	}
+
+	ctx.ContentType("application/json")
+	return string(res)
+}
+
+func hasCoordinate(loc geotypes.Location) bool {
+	return loc.Coordinate.Latitude != 0 || loc.Coordinate.Longitude != 0
+}
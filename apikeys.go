@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	viaErr "github.com/nfleet/via/error"
+)
+
+// APIKey tells what the client holding the key may do. Zero limits are
+// unlimited.
+type APIKey struct {
+	// Countries the key may use; empty allows every country of the server.
+	Countries []string
+	// Most sources or targets in a matrix, and most paths in a request.
+	MaxDimension int
+	// Quotas over fixed windows of a minute and an hour. A matrix counts its
+	// cells, a paths request its paths.
+	RequestsPerMinute int
+	CellsPerHour      int
+}
+
+type keyUsage struct {
+	minute   time.Time
+	requests int
+	hour     time.Time
+	cells    int
+}
+
+// Keyring checks requests against the API keys and keeps track of their
+// quotas.
+type Keyring struct {
+	keys map[string]APIKey
+	now  func() time.Time
+
+	mu    sync.Mutex
+	usage map[string]*keyUsage
+}
+
+func NewKeyring(keys map[string]APIKey) *Keyring {
+	return &Keyring{
+		keys:  keys,
+		now:   time.Now,
+		usage: make(map[string]*keyUsage),
+	}
+}
+
+// Authenticate returns the key sent in an Authorization header, which is
+// either "Bearer <key>" or just the key.
+func (k *Keyring) Authenticate(authorization string) (string, *viaErr.Error) {
+	key := strings.TrimSpace(authorization)
+	if len(key) > 7 && strings.EqualFold(key[:7], "bearer ") {
+		key = strings.TrimSpace(key[7:])
+	}
+
+	if key == "" {
+		return "", viaErr.NewRequestError(viaErr.ReqErrUnauthorized, "missing API key")
+	}
+	if _, ok := k.keys[key]; !ok {
+		return "", viaErr.NewRequestError(viaErr.ReqErrUnauthorized, "unknown API key")
+	}
+	return key, nil
+}
+
+// Allow checks that key may compute a rows x cols matrix in country and
+// charges it to the quotas of the key. Paths requests are a column of rows.
+// A cached result counts as a request but costs no cells.
+func (k *Keyring) Allow(key, country string, rows, cols int, cached bool) *viaErr.Error {
+	apiKey := k.keys[key]
+
+	if len(apiKey.Countries) > 0 && !containsString(apiKey.Countries, country) {
+		msg := fmt.Sprintf("API key may not use %s, only %v", country, apiKey.Countries)
+		return viaErr.NewRequestError(viaErr.ReqErrForbidden, msg)
+	}
+	if apiKey.MaxDimension > 0 && (rows > apiKey.MaxDimension || cols > apiKey.MaxDimension) {
+		msg := fmt.Sprintf("%dx%d exceeds the API key's limit of %d", rows, cols, apiKey.MaxDimension)
+		return viaErr.NewRequestError(viaErr.ReqErrTooLarge, msg)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := k.now()
+	usage, ok := k.usage[key]
+	if !ok {
+		usage = &keyUsage{}
+		k.usage[key] = usage
+	}
+	if now.Sub(usage.minute) >= time.Minute {
+		usage.minute, usage.requests = now, 0
+	}
+	if now.Sub(usage.hour) >= time.Hour {
+		usage.hour, usage.cells = now, 0
+	}
+
+	cells := rows * cols
+	if cached {
+		cells = 0
+	}
+	if apiKey.RequestsPerMinute > 0 && usage.requests+1 > apiKey.RequestsPerMinute {
+		msg := fmt.Sprintf("API key is limited to %d requests per minute", apiKey.RequestsPerMinute)
+		return viaErr.NewRequestError(viaErr.ReqErrQuotaExceeded, msg)
+	}
+	if apiKey.CellsPerHour > 0 && usage.cells+cells > apiKey.CellsPerHour {
+		msg := fmt.Sprintf("API key has %d of %d cells per hour left, the request needs %d", apiKey.CellsPerHour-usage.cells, apiKey.CellsPerHour, cells)
+		return viaErr.NewRequestError(viaErr.ReqErrQuotaExceeded, msg)
+	}
+	usage.requests++
+	usage.cells += cells
+	return nil
+}
+
+func containsString(list []string, a string) bool {
+	for _, b := range list {
+		if strings.EqualFold(a, b) {
+			return true
+		}
+	}
+	return false
+}
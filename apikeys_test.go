@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	viaErr "github.com/nfleet/via/error"
+)
+
+func TestAuthenticate(t *testing.T) {
+	keys := NewKeyring(map[string]APIKey{"secret": {}})
+
+	tests := []struct {
+		header string
+		ok     bool
+	}{
+		{"Bearer secret", true},
+		{"bearer  secret ", true},
+		{"secret", true},
+		{"Bearer other", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		key, e := keys.Authenticate(tt.header)
+		if tt.ok && (e != nil || key != "secret") {
+			t.Errorf("Authenticate(%q) => %q, %v, want secret", tt.header, key, e)
+		}
+		if !tt.ok && (e == nil || e.ErrorCode != viaErr.ReqErrUnauthorized) {
+			t.Errorf("Authenticate(%q) => %v, want unauthorized", tt.header, e)
+		}
+	}
+}
+
+func TestAllow(t *testing.T) {
+	keys := NewKeyring(map[string]APIKey{
+		"k": {Countries: []string{"finland"}, MaxDimension: 10, RequestsPerMinute: 2, CellsPerHour: 150},
+	})
+	now := time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+	keys.now = func() time.Time { return now }
+
+	code := func(e *viaErr.Error) int {
+		if e == nil {
+			return 0
+		}
+		return e.ErrorCode
+	}
+
+	if c := code(keys.Allow("k", "germany", 1, 1, false)); c != viaErr.ReqErrForbidden {
+		t.Errorf("other country => %d, want forbidden", c)
+	}
+	if c := code(keys.Allow("k", "finland", 11, 1, false)); c != viaErr.ReqErrTooLarge {
+		t.Errorf("too many sources => %d, want too large", c)
+	}
+	if c := code(keys.Allow("k", "finland", 10, 10, false)); c != 0 {
+		t.Errorf("first request => %d, want allowed", c)
+	}
+	if c := code(keys.Allow("k", "finland", 10, 10, false)); c != viaErr.ReqErrQuotaExceeded {
+		t.Errorf("request over the cell quota => %d, want quota exceeded", c)
+	}
+	if c := code(keys.Allow("k", "finland", 5, 1, false)); c != 0 {
+		t.Errorf("second request => %d, want allowed", c)
+	}
+	if c := code(keys.Allow("k", "finland", 1, 1, false)); c != viaErr.ReqErrQuotaExceeded {
+		t.Errorf("third request in a minute => %d, want quota exceeded", c)
+	}
+
+	now = now.Add(time.Minute)
+	if c := code(keys.Allow("k", "finland", 1, 1, false)); c != 0 {
+		t.Errorf("request in the next minute => %d, want allowed", c)
+	}
+	if c := code(keys.Allow("k", "finland", 10, 10, true)); c != 0 {
+		t.Errorf("cached result over the cell quota => %d, want allowed", c)
+	}
+}
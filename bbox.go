@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	viaErr "github.com/nfleet/via/error"
+	"github.com/nfleet/via/geotypes"
+)
+
+// BoundingBox is the area the coordinates of a country must fall in.
+type BoundingBox struct {
+	LatMin float64
+	LatMax float64
+	LonMin float64
+	LonMax float64
+}
+
+// Contains tells whether the [latitude, longitude] pair c lies in the box.
+func (b BoundingBox) Contains(c geotypes.Coord) bool {
+	return len(c) == 2 &&
+		c[0] >= b.LatMin && c[0] <= b.LatMax &&
+		c[1] >= b.LonMin && c[1] <= b.LonMax
+}
+
+// The bounding boxes of the countries with built-in graphs.
+var defaultBoundingBoxes = map[string]BoundingBox{
+	// From Hanko to Nuorgam, and from the Swedish-Norwegian border to Ilomantsi.
+	"finland": {LatMin: 59.807983, LatMax: 70.092283, LonMin: 20.54, LonMax: 31.5867},
+	// From Oberstdorf to Aventoft, and from Isenbruch to Neißeaue.
+	"germany": {LatMin: 47.270108, LatMax: 54.9, LonMin: 5.8666667, LonMax: 15.033333},
+}
+
+// The file in the data directory with more bounding boxes.
+const boundingBoxFile = "bounding_boxes.json"
+
+// LoadBoundingBoxes returns the default bounding boxes, overridden by those in
+// bounding_boxes.json of dataDir, if there is one, and then by configured.
+func LoadBoundingBoxes(dataDir string, configured map[string]BoundingBox) (map[string]BoundingBox, error) {
+	boxes := make(map[string]BoundingBox)
+	for country, box := range defaultBoundingBoxes {
+		boxes[country] = box
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dataDir, boundingBoxFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		var fromFile map[string]BoundingBox
+		if err := json.Unmarshal(data, &fromFile); err != nil {
+			return nil, err
+		}
+		for country, box := range fromFile {
+			boxes[country] = box
+		}
+	}
+
+	for country, box := range configured {
+		boxes[country] = box
+	}
+	return boxes, nil
+}
+
+// Checks that every coordinate lies within the bounding box of country.
+// Returns false and the indices of the offending coordinates otherwise.
+// Countries without a bounding box accept every coordinate.
+func (server *Server) check_coordinate_sanity(coords []geotypes.Coord, country string) (bool, []int) {
+	box, ok := server.BoundingBoxes[country]
+	if !ok {
+		return true, nil
+	}
+
+	var outside []int
+	for i, c := range coords {
+		if !box.Contains(c) {
+			outside = append(outside, i)
+		}
+	}
+	return len(outside) == 0, outside
+}
+
+// Returns an error naming list if any of its coordinates lies outside of
+// country, nil otherwise.
+func (server *Server) checkCoordinates(list string, coords []geotypes.Coord, country string) *viaErr.Error {
+	if ok, outside := server.check_coordinate_sanity(coords, country); !ok {
+		return viaErr.NewOutOfBoundsError(list, country, outside)
+	}
+	return nil
+}
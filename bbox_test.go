@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/nfleet/via/geotypes"
+)
+
+func TestCoordinateSanity(t *testing.T) {
+	server := Server{BoundingBoxes: map[string]BoundingBox{
+		"finland": {LatMin: 59.807983, LatMax: 70.092283, LonMin: 20.54, LonMax: 31.5867},
+	}}
+
+	var tests = []struct {
+		coords  []geotypes.Coord
+		country string
+		ok      bool
+		outside []int
+	}{
+		{[]geotypes.Coord{{60.0, 25.0}, {62.24, 25.74}}, "finland", true, nil},
+		{[]geotypes.Coord{{60.0, 25.0}, {50.0, 25.0}, {61.0, 19.0}}, "finland", false, []int{1, 2}},
+		{[]geotypes.Coord{{45.0, 10.0}}, "germany", true, nil},
+	}
+
+	for i, test := range tests {
+		ok, outside := server.check_coordinate_sanity(test.coords, test.country)
+		if ok != test.ok || !reflect.DeepEqual(outside, test.outside) {
+			t.Errorf("%d. check_coordinate_sanity(%v, %q) => %v, %v, want %v, %v", i, test.coords, test.country, ok, outside, test.ok, test.outside)
+		}
+	}
+
+	e := server.checkCoordinates("targets", []geotypes.Coord{{60.0, 25.0}, {50.0, 25.0}}, "finland")
+	if e == nil || e.Cause != "coordinates of targets outside of finland" || !reflect.DeepEqual(e.Indices, []int{1}) {
+		t.Errorf("checkCoordinates of targets => %+v", e)
+	}
+}
+
+func TestBoundingBoxes(t *testing.T) {
+	var tests = []struct {
+		coords  []geotypes.Coord
+		country string
+		ok      bool
+	}{
+		{[]geotypes.Coord{{60.0, 25.0}}, "finland", true},
+		{[]geotypes.Coord{{50.0, 25.0}}, "finland", false},
+		{[]geotypes.Coord{{61.0, 19.0}}, "finland", false},
+		{[]geotypes.Coord{{50.0, 10.0}}, "germany", true},
+		{[]geotypes.Coord{{45.0, 10.0}}, "germany", false},
+		{[]geotypes.Coord{{50.0, 4.05}}, "germany", false},
+	}
+
+	server := Server{BoundingBoxes: defaultBoundingBoxes}
+	for i, test := range tests {
+		if ok, _ := server.check_coordinate_sanity(test.coords, test.country); ok != test.ok {
+			t.Errorf("%d. check_coordinate_sanity(%v, %q) => %v, want %v", i, test.coords, test.country, ok, test.ok)
+		}
+	}
+}
+
+func TestLoadBoundingBoxes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "via-bboxes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	boxes, err := LoadBoundingBoxes(dir, nil)
+	if err != nil || !reflect.DeepEqual(boxes, defaultBoundingBoxes) {
+		t.Errorf("without a file => %v, %v, want the defaults", boxes, err)
+	}
+
+	data := `{"estonia": {"LatMin": 57.5, "LatMax": 59.7, "LonMin": 21.7, "LonMax": 28.2}, "finland": {"LatMin": 1, "LatMax": 2, "LonMin": 3, "LonMax": 4}}`
+	if err := ioutil.WriteFile(filepath.Join(dir, boundingBoxFile), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	configured := map[string]BoundingBox{"finland": {LatMin: 59, LatMax: 71, LonMin: 20, LonMax: 32}}
+	boxes, err = LoadBoundingBoxes(dir, configured)
+	want := map[string]BoundingBox{
+		"estonia": {LatMin: 57.5, LatMax: 59.7, LonMin: 21.7, LonMax: 28.2},
+		"finland": configured["finland"],
+		"germany": defaultBoundingBoxes["germany"],
+	}
+	if err != nil || !reflect.DeepEqual(boxes, want) {
+		t.Errorf("with a file and config => %v, %v, want %v", boxes, err, want)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, boundingBoxFile), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadBoundingBoxes(dir, nil); err == nil {
+		t.Error("malformed file was accepted")
+	}
+}
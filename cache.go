@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Size of the in-memory cache unless the config says otherwise.
+const defaultCacheMegabytes = 256
+
+// Cache keeps encoded results for a while. Missing and expired entries are
+// misses, and so are failures of the cache itself: the result is computed
+// again.
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte, ttl time.Duration)
+}
+
+// Returns the cache key of a computation of kind from sources to targets.
+func cacheKey(kind, country string, speedProfile SpeedProfile, sources, targets []int) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s|%s|%d|", country, speedProfile, len(sources))
+	buf := make([]byte, 4)
+	for _, ids := range [][]int{sources, targets} {
+		for _, id := range ids {
+			binary.LittleEndian.PutUint32(buf, uint32(id))
+			h.Write(buf)
+		}
+	}
+	return "via:" + kind + ":" + hex.EncodeToString(h.Sum(nil))
+}
+
+// Encodes a matrix for the cache as the rows, the columns and the weights,
+// all little-endian uint32.
+func (m Matrix) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 8+4*len(m.Weights))
+	binary.LittleEndian.PutUint32(buf, uint32(m.Rows))
+	binary.LittleEndian.PutUint32(buf[4:], uint32(m.Cols))
+	for i, w := range m.Weights {
+		binary.LittleEndian.PutUint32(buf[8+4*i:], w)
+	}
+	return buf, nil
+}
+
+func (m *Matrix) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 {
+		return errors.New("matrix too short")
+	}
+	rows := int(binary.LittleEndian.Uint32(data))
+	cols := int(binary.LittleEndian.Uint32(data[4:]))
+	if len(data) != 8+4*rows*cols {
+		return fmt.Errorf("%dx%d matrix has %d bytes", rows, cols, len(data))
+	}
+	weights := make([]uint32, rows*cols)
+	for i := range weights {
+		weights[i] = binary.LittleEndian.Uint32(data[8+4*i:])
+	}
+	m.Rows, m.Cols, m.Weights = rows, cols, weights
+	return nil
+}
+
+// LRUCache is an in-process cache that holds at most maxBytes of values,
+// dropping the least recently used ones first.
+type LRUCache struct {
+	maxBytes int
+	now      func() time.Time
+
+	mu      sync.Mutex
+	bytes   int
+	order   *list.List // front is the most recently used
+	entries map[string]*list.Element
+}
+
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+func NewLRUCache(maxBytes int) *LRUCache {
+	return &LRUCache{
+		maxBytes: maxBytes,
+		now:      time.Now,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (c *LRUCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*lruEntry)
+	if !c.now().Before(entry.expires) {
+		c.remove(elem)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.value, true
+}
+
+func (c *LRUCache) Set(key string, value []byte, ttl time.Duration) {
+	if len(value) > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry{key, value, c.now().Add(ttl)})
+	c.bytes += len(value)
+	for c.bytes > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *LRUCache) remove(elem *list.Element) {
+	entry := c.order.Remove(elem).(*lruEntry)
+	delete(c.entries, entry.key)
+	c.bytes -= len(entry.value)
+}
+
+// RedisCache keeps the results in Redis, so that they survive restarts and
+// are shared between servers.
+type RedisCache struct {
+	addr, password string
+	timeout        time.Duration
+
+	mu   sync.Mutex
+	idle []*redisConn
+}
+
+// Most connections kept open between requests.
+const redisMaxIdle = 8
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// redisError is an error reply of Redis.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+func NewRedisCache(addr, password string) *RedisCache {
+	return &RedisCache{addr: addr, password: password, timeout: 5 * time.Second}
+}
+
+func (c *RedisCache) Get(key string) ([]byte, bool) {
+	reply, err := c.do("GET", key)
+	if err != nil {
+		log.Printf("cache: getting %s failed: %s", key, err.Error())
+		return nil, false
+	}
+	value, ok := reply.([]byte)
+	return value, ok
+}
+
+func (c *RedisCache) Set(key string, value []byte, ttl time.Duration) {
+	ms := strconv.FormatInt(int64(ttl/time.Millisecond), 10)
+	if _, err := c.do("SET", key, value, "PX", ms); err != nil {
+		log.Printf("cache: setting %s failed: %s", key, err.Error())
+	}
+}
+
+// Sends a command and returns its reply, see readRESP.
+func (c *RedisCache) do(args ...interface{}) (interface{}, error) {
+	conn, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(c.timeout))
+	reply, err := conn.command(args...)
+	if _, ok := err.(redisError); err != nil && !ok {
+		conn.Close()
+		return nil, err
+	}
+	c.put(conn)
+	return reply, err
+}
+
+func (c *RedisCache) get() (*redisConn, error) {
+	c.mu.Lock()
+	if n := len(c.idle); n > 0 {
+		conn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return conn, nil
+	}
+	c.mu.Unlock()
+
+	nc, err := net.DialTimeout("tcp", c.addr, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{nc, bufio.NewReader(nc)}
+	if c.password != "" {
+		conn.SetDeadline(time.Now().Add(c.timeout))
+		if _, err := conn.command("AUTH", c.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (c *RedisCache) put(conn *redisConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.idle) >= redisMaxIdle {
+		conn.Close()
+		return
+	}
+	c.idle = append(c.idle, conn)
+}
+
+func (conn *redisConn) command(args ...interface{}) (interface{}, error) {
+	if err := writeRESP(conn, args...); err != nil {
+		return nil, err
+	}
+	return readRESP(conn.r)
+}
+
+// Writes a command as an array of bulk strings. The arguments are strings or
+// byte slices.
+func writeRESP(w io.Writer, args ...interface{}) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "*%d\r\n", len(args))
+	for _, arg := range args {
+		var b []byte
+		switch arg := arg.(type) {
+		case string:
+			b = []byte(arg)
+		case []byte:
+			b = arg
+		default:
+			return fmt.Errorf("redis: can't send %T", arg)
+		}
+		fmt.Fprintf(bw, "$%d\r\n", len(b))
+		bw.Write(b)
+		bw.WriteString("\r\n")
+	}
+	return bw.Flush()
+}
+
+// Reads a reply: a string for a status, an int64 for an integer, a []byte for
+// a bulk string, nil for a missing value and []interface{} for an array.
+// Error replies are returned as a redisError.
+func readRESP(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, line := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return nil, redisError(line)
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$', '*':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		if kind == '*' {
+			array := make([]interface{}, n)
+			for i := range array {
+				if array[i], err = readRESP(r); err != nil {
+					return nil, err
+				}
+			}
+			return array, nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply %q", kind)
+}
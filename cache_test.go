@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis serves GET, SET with PX and AUTH from memory, like a local Redis.
+type fakeRedis struct {
+	net.Listener
+	password string
+	now      func() time.Time
+
+	mu      sync.Mutex
+	values  map[string]string
+	expires map[string]time.Time
+}
+
+func startFakeRedis(t *testing.T, password string) *fakeRedis {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &fakeRedis{
+		Listener: l,
+		password: password,
+		now:      time.Now,
+		values:   make(map[string]string),
+		expires:  make(map[string]time.Time),
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+	return r
+}
+
+func (r *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	in := bufio.NewReader(conn)
+	authed := r.password == ""
+
+	for {
+		request, err := readRESP(in)
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, arg := range request.([]interface{}) {
+			args = append(args, string(arg.([]byte)))
+		}
+
+		var reply string
+		switch cmd := strings.ToUpper(args[0]); {
+		case cmd == "AUTH" && args[1] == r.password:
+			authed = true
+			reply = "+OK\r\n"
+		case cmd == "AUTH":
+			reply = "-ERR invalid password\r\n"
+		case !authed:
+			reply = "-NOAUTH Authentication required.\r\n"
+		case cmd == "GET":
+			reply = "$-1\r\n"
+			if value, ok := r.get(args[1]); ok {
+				reply = "$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"
+			}
+		case cmd == "SET":
+			ms, _ := strconv.Atoi(args[4])
+			r.mu.Lock()
+			r.values[args[1]] = args[2]
+			r.expires[args[1]] = r.now().Add(time.Duration(ms) * time.Millisecond)
+			r.mu.Unlock()
+			reply = "+OK\r\n"
+		default:
+			reply = "-ERR unknown command\r\n"
+		}
+		conn.Write([]byte(reply))
+	}
+}
+
+func (r *fakeRedis) get(key string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	value, ok := r.values[key]
+	if !ok || !r.now().Before(r.expires[key]) {
+		return "", false
+	}
+	return value, true
+}
+
+func TestRedisCache(t *testing.T) {
+	r := startFakeRedis(t, "secret")
+	defer r.Close()
+
+	c := NewRedisCache(r.Addr().String(), "secret")
+	if _, ok := c.Get("a"); ok {
+		t.Error("Get of a missing key hit")
+	}
+	c.Set("a", []byte("binary\r\n\x00value"), time.Minute)
+	if value, ok := c.Get("a"); !ok || string(value) != "binary\r\n\x00value" {
+		t.Errorf("Get(a) => %q, %v", value, ok)
+	}
+
+	r.mu.Lock()
+	r.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	r.mu.Unlock()
+	if _, ok := c.Get("a"); ok {
+		t.Error("Get of an expired key hit")
+	}
+
+	wrong := NewRedisCache(r.Addr().String(), "wrong")
+	wrong.Set("b", []byte("x"), time.Minute)
+	if _, ok := wrong.Get("b"); ok {
+		t.Error("Get with a wrong password hit")
+	}
+}
+
+func TestLRUCache(t *testing.T) {
+	c := NewLRUCache(10)
+	now := time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	c.Set("a", []byte("aaaa"), time.Minute)
+	c.Set("b", []byte("bbbb"), time.Minute)
+	c.Get("a")
+	c.Set("c", []byte("cccc"), time.Minute)
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("least recently used entry was kept")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("%s was dropped", key)
+		}
+	}
+
+	c.Set("big", make([]byte, 11), time.Minute)
+	if _, ok := c.Get("big"); ok {
+		t.Error("entry larger than the cache was kept")
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := c.Get("a"); ok {
+		t.Error("expired entry hit")
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	keys := map[string]bool{}
+	for _, k := range []string{
+		cacheKey("matrix", "finland", "100", []int{1, 2}, []int{3}),
+		cacheKey("matrix", "finland", "100", []int{1}, []int{2, 3}),
+		cacheKey("matrix", "finland", "80", []int{1, 2}, []int{3}),
+		cacheKey("matrix", "germany", "100", []int{1, 2}, []int{3}),
+		cacheKey("distances", "finland", "100", []int{1, 2}, []int{3}),
+	} {
+		if keys[k] {
+			t.Errorf("%s is not unique", k)
+		}
+		keys[k] = true
+	}
+	if matrixKey("matrix", "finland", "100", []int{1, 2}, nil) != cacheKey("matrix", "finland", "100", []int{1, 2}, []int{1, 2}) {
+		t.Error("matrix without targets has another key than the same matrix with them")
+	}
+}
+
+func TestCachedMatrix(t *testing.T) {
+	v := NewVia(false, false, 60, "")
+	m := Matrix{Rows: 2, Cols: 3, Weights: []uint32{1, 2, 3, 4, 5, unreachable}}
+	v.cacheMatrix(matrixKey("matrix", "finland", "100", []int{1, 2}, []int{3, 4, 5}), m)
+
+	// No graph is loaded, so the matrix can only come from the cache.
+	got, err := v.ComputeMatrix([]int{1, 2}, []int{3, 4, 5}, "finland", "100", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("ComputeMatrix => %v, want %v", got, m)
+	}
+
+	var rows [][]uint32
+	err = v.StreamMatrix([]int{1, 2}, []int{3, 4, 5}, "finland", "100", false, func(i int, weights, distances []uint32) error {
+		rows = append(rows, append([]uint32(nil), weights...))
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(rows, [][]uint32{{1, 2, 3}, {4, 5, unreachable}}) {
+		t.Errorf("StreamMatrix => %v, %v", rows, err)
+	}
+
+	d := Matrix{Rows: 2, Cols: 3, Weights: []uint32{10, 20, 30, 40, 50, unreachable}}
+	v.cacheMatrix(matrixKey("distances", "finland", "100", []int{1, 2}, []int{3, 4, 5}), d)
+	var distanceRows [][]uint32
+	err = v.StreamMatrix([]int{1, 2}, []int{3, 4, 5}, "finland", "100", true, func(i int, weights, distances []uint32) error {
+		distanceRows = append(distanceRows, append([]uint32(nil), distances...))
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(distanceRows, [][]uint32{{10, 20, 30}, {40, 50, unreachable}}) {
+		t.Errorf("StreamMatrix with distances => %v, %v", distanceRows, err)
+	}
+}
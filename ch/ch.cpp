@@ -4,6 +4,7 @@
 #include <rapidjson/stringbuffer.h>
 #include <rapidjson/writer.h>
 
+#include <cmath>
 #include <iostream>
 #include <string>
 #include <iomanip>
@@ -21,6 +22,7 @@ using namespace std;
 #include "processing/DijkstraCH.h"
 
 #include "manyToMany.h"
+#include "ch.h"
 
 typedef datastr::graph::SearchGraph TransitGraph;
 typedef datastr::graph::SearchGraph MyGraph;
@@ -42,21 +44,30 @@ Counter counter;
 
 inline NodeID mapNodeID(const MyGraph* const g, const NodeID u) {
   // map the actual node ID to the node ID that is used internally by
-  // highway-node routing
+  // highway-node routing; asserts are off, so check the ID here
+  if (u >= g->noOfNodes()) {
+    stringstream ss;
+    ss << "node " << u << " is not in the graph of " << g->noOfNodes() << " nodes";
+    throw std::out_of_range(ss.str());
+  }
   return g->mapExtToIntNodeID(u);
 }
 
-MyGraph* loadGraph(const std::string& country, const int speed_profile,
-                   const std::string& dataDir) {
-  std::ostringstream filename;
-  filename << dataDir << country << "-" << speed_profile << ".sgr";
-
-  std::string path = filename.str();
+/*
+ * Reads the node ID at key of a JSON object.
+ */
+NodeID parseNodeID(const rapidjson::Value& v, const char* key) {
+  if (!v.IsObject() || !v.HasMember(key) || !v[key].IsUint()) {
+    throw ParseError(std::string("missing or invalid node ID \"") + key + "\"");
+  }
+  return (NodeID)v[key].GetUint();
+}
 
+MyGraph* loadGraph(const std::string& path) {
   ifstream inGraph(path.c_str(), ios::binary);
 
   if (!inGraph) {
-    throw std::invalid_argument("File " + filename.str() + " could not be read.");
+    throw GraphNotFound("File " + path + " could not be read.");
   }
 
   MyGraph* graph = new MyGraph(inGraph);
@@ -64,175 +75,252 @@ MyGraph* loadGraph(const std::string& country, const int speed_profile,
   return graph;
 }
 
-const std::string calc_dm(const std::string& json_data,
-                          const std::string& country, const int speed_profile,
-                          const std::string& dataDir) {
-  rapidjson::Document d;
-  LevelID earlyStopLevel = 10;
-  MyGraph* graph;
+Graph::Graph(MyGraph* g) : _g(g), _intToExt(g->noOfNodes()) {
+  for (NodeID u = 0; u < g->noOfNodes(); u++) {
+    _intToExt[g->mapExtToIntNodeID(u)] = u;
+  }
+}
 
-  try {
-    graph = loadGraph(country, speed_profile, dataDir);
+Graph::~Graph() { delete _g; }
+
+unsigned int Graph::noOfNodes() const { return _g->noOfNodes(); }
+
+unsigned int Graph::noOfEdges() const { return _g->noOfEdges(); }
+
+unsigned long long Graph::memoryUsage() const {
+  return _g->memoryUsage() + (unsigned long long)_intToExt.size() * sizeof(NodeID);
+}
+
+Graph* load_graph(const std::string& path) {
+  return new Graph(loadGraph(path));
+}
+
+GraphFileInfo read_graph_info(const std::string& path) {
+  ifstream in(path.c_str(), ios::binary);
+  if (!in) {
+    throw GraphNotFound("File " + path + " could not be read.");
   }
-  catch (std::invalid_argument& e) {
-    return "{}";
+
+  // SearchGraph::serialize writes the size of the nodes, which end with a
+  // dummy node, then the nodes and then the size of the edges.
+  NodeID nodes = 0, edges = 0;
+  readPrimitive(in, nodes);
+  in.seekg((streamoff)nodes * sizeof(MyGraph::SearchNode), ios::cur);
+  readPrimitive(in, edges);
+  if (!in || nodes == 0) {
+    throw ParseError("File " + path + " is not a graph.");
   }
 
-  d.Parse<0>(json_data.c_str());
+  GraphFileInfo info = {nodes - 1, edges};
+  return info;
+}
 
-  const rapidjson::Value& sources = d["sources"];
-  assert(sources.IsArray());
-  vector<NodeID> v_sources;
+/*
+ * Maps n external node IDs to internal ones.
+ */
+void mapNodeIDs(const MyGraph* graph, const unsigned int* ids, const int n,
+                vector<NodeID>& v_ids) {
+  v_ids.reserve(n);
+  for (int i = 0; i < n; i++) {
+    v_ids.push_back(mapNodeID(graph, ids[i]));
+  }
+}
 
-  for (rapidjson::SizeType i = 0; i < sources.Size(); i++) {
-    v_sources.push_back(mapNodeID(graph, (NodeID)sources[i].GetUint()));
+/*
+ * The source rows are split evenly across threads. Every thread runs its own
+ * many-to-many search on the shared graph and copies its rows into weights,
+ * so the result does not depend on the number of threads.
+ */
+void calc_matrix(const Graph* g,
+                 const unsigned int* sources, const int noOfSources,
+                 const unsigned int* targets, const int noOfTargets,
+                 unsigned int* weights, const int noOfWeights,
+                 const int threads) {
+  LevelID earlyStopLevel = 10;
+  MyGraph* graph = g->searchGraph();
+
+  vector<NodeID> v_sources;
+  vector<NodeID> v_targets;
+  mapNodeIDs(graph, sources, noOfSources, v_sources);
+  if (noOfTargets > 0) {
+    mapNodeIDs(graph, targets, noOfTargets, v_targets);
+  } else {
+    v_targets = v_sources;
   }
 
-  ManyToMany<MyGraph, DijkstraManyToManyFW, DijkstraManyToManyBW,
-             performBucketScans> mtm(graph, earlyStopLevel);
-  Matrix<EdgeWeight> matrix((NodeID)sources.Size(), (NodeID)sources.Size());
-  mtm.computeMatrix(v_sources, v_sources, matrix);
+  const NodeID noOfRows = v_sources.size();
+  const NodeID noOfCols = v_targets.size();
+  if ((unsigned long long)noOfRows * noOfCols != (unsigned long long)noOfWeights) {
+    stringstream ss;
+    ss << "weights hold " << noOfWeights << " values, the matrix has "
+       << noOfRows << "x" << noOfCols;
+    throw std::invalid_argument(ss.str());
+  }
 
-  int noOfRows = matrix.noOfRows();
-  int noOfCols = matrix.noOfCols();
+  int noOfThreads = threads > 0 ? threads : omp_get_max_threads();
+  if ((NodeID)noOfThreads > noOfRows) {
+    noOfThreads = noOfRows > 0 ? noOfRows : 1;
+  }
 
-  rapidjson::Document out_doc;
-  out_doc.SetObject();
-  vector<string> names;
+#pragma omp parallel for num_threads(noOfThreads) schedule(static, 1)
+  for (int thr = 0; thr < noOfThreads; thr++) {
+    const NodeID first = (NodeID)((unsigned long long)noOfRows * thr / noOfThreads);
+    const NodeID last = (NodeID)((unsigned long long)noOfRows * (thr + 1) / noOfThreads);
 
-  for (int j = 0; j < noOfRows; j++) {
-    rapidjson::Value result;
-    result.SetArray();
-    rapidjson::Document::AllocatorType& allocator = out_doc.GetAllocator();
+    vector<NodeID> rows(v_sources.begin() + first, v_sources.begin() + last);
+    ManyToMany<MyGraph, DijkstraManyToManyFW, DijkstraManyToManyBW,
+               performBucketScans> mtm(graph, earlyStopLevel);
+    Matrix<EdgeWeight> part(last - first, noOfCols);
+    mtm.computeMatrix(rows, v_targets, part);
 
-    for (int k = 0; k < noOfCols; k++) {
-      result.PushBack(matrix.value(j, k), allocator);
+    for (NodeID r = 0; r < part.noOfRows(); r++) {
+      for (NodeID c = 0; c < noOfCols; c++) {
+        weights[(unsigned long long)(first + r) * noOfCols + c] = part.value(r, c);
+      }
     }
-
-    stringstream ss;
-    ss << j;
-    string str = ss.str();
-    names.push_back(str);
-    out_doc.AddMember(names[j].c_str(), result, out_doc.GetAllocator());
   }
+}
 
-  rapidjson::StringBuffer strbuf;
-  rapidjson::Writer<rapidjson::StringBuffer> writer(strbuf);
-  out_doc.Accept(writer);
+typedef ManyToMany<MyGraph, DijkstraManyToManyFW, DijkstraManyToManyBW,
+                   performBucketScans> MyManyToMany;
 
-  delete graph;
+struct MatrixRows::Impl {
+  Impl(MyGraph* graph) : graph(graph), mtm(graph, 10) {}
 
-  return strbuf.GetString();
+  MyGraph* graph;
+  MyManyToMany mtm;
+  NodeID noOfTargets;
+};
+
+MatrixRows::MatrixRows(const Graph* g, const unsigned int* targets,
+                       const int noOfTargets)
+    : _impl(new Impl(g->searchGraph())) {
+  try {
+    vector<NodeID> v_targets;
+    mapNodeIDs(_impl->graph, targets, noOfTargets, v_targets);
+    _impl->noOfTargets = v_targets.size();
+    _impl->mtm.prepareTargets(v_targets);
+  } catch (...) {
+    delete _impl;
+    throw;
+  }
 }
 
-/*
-const std::string calc_dm_par(const std::string& json_data,
-                              const std::string& country,
-                              const int speed_profile,
-                              const std::string& dataDir) {
-  std::cout << "parallel is enabled" << std::endl;
-  rapidjson::Document d;
-  LevelID earlyStopLevel = 10;
+MatrixRows::~MatrixRows() { delete _impl; }
 
-  std::stringstream filename;
+void MatrixRows::row(const unsigned int source, unsigned int* weights,
+                     const int noOfWeights) {
+  if ((NodeID)noOfWeights != _impl->noOfTargets) {
+    stringstream ss;
+    ss << "weights hold " << noOfWeights << " values, there are "
+       << _impl->noOfTargets << " targets";
+    throw std::invalid_argument(ss.str());
+  }
+  _impl->mtm.computeRow(mapNodeID(_impl->graph, source), weights);
+}
 
-  MyGraph* graph = loadGraph(country, speed_profile, dataDir);
+/*
+ * Great-circle distance between two nodes in meters.
+ */
+double haversine(const float lat1, const float lon1, const float lat2, const float lon2) {
+  const double rad = M_PI / 180;
+  const double dLat = (lat2 - lat1) * rad;
+  const double dLon = (lon2 - lon1) * rad;
+  const double h = sin(dLat / 2) * sin(dLat / 2) +
+                   cos(lat1 * rad) * cos(lat2 * rad) * sin(dLon / 2) * sin(dLon / 2);
+  return 2 * 6371000.0 * asin(min(1.0, sqrt(h)));
+}
 
-  d.Parse<0>(json_data.c_str());
+/*
+ * Every pair is searched on its own and its path unpacked, so this costs a
+ * point-to-point query per cell. The pairs are split across threads.
+ */
+void calc_distances(const Graph* g,
+                    const unsigned int* sources, const int noOfSources,
+                    const unsigned int* targets, const int noOfTargets,
+                    const float* lats, const int noOfLats,
+                    const float* lons, const int noOfLons,
+                    unsigned int* distances, const int noOfDistances,
+                    const int threads) {
+  MyGraph* graph = g->searchGraph();
 
-  const rapidjson::Value& sources = d["sources"];
-  assert(sources.IsArray());
   vector<NodeID> v_sources;
+  vector<NodeID> v_targets;
+  mapNodeIDs(graph, sources, noOfSources, v_sources);
+  if (noOfTargets > 0) {
+    mapNodeIDs(graph, targets, noOfTargets, v_targets);
+  } else {
+    v_targets = v_sources;
+  }
 
-  for (rapidjson::SizeType i = 0; i < sources.Size(); i++) {
-    v_sources.push_back(mapNodeID(graph, (NodeID)sources[i].GetUint()));
+  const NodeID noOfRows = v_sources.size();
+  const NodeID noOfCols = v_targets.size();
+  if ((unsigned long long)noOfRows * noOfCols != (unsigned long long)noOfDistances) {
+    stringstream ss;
+    ss << "distances hold " << noOfDistances << " values, the matrix has "
+       << noOfRows << "x" << noOfCols;
+    throw std::invalid_argument(ss.str());
   }
-  rapidjson::Document out_doc;
-  out_doc.SetObject();
-  int max_thr = omp_get_max_threads();
-  int thr_size = v_sources.size() / max_thr;
-  int size_rest = v_sources.size() % max_thr;
-  vector<int> matr_cols;
-  for (int j = 0; j < max_thr; j++) {
-    matr_cols.push_back(thr_size);
+  if (noOfLats != noOfLons || (NodeID)noOfLats < graph->noOfNodes()) {
+    stringstream ss;
+    ss << "coordinates of " << noOfLats << "/" << noOfLons << " nodes given, the graph has "
+       << graph->noOfNodes();
+    throw std::invalid_argument(ss.str());
   }
-  matr_cols[matr_cols.size() - 1] += size_rest;
-
-#pragma omp parallel for ordered schedule(dynamic)
-  for (int matr_id = 0; matr_id < max_thr; matr_id++) {
-    MyGraph* const graph_thr = new MyGraph(*graph);
-    int num_threads = omp_get_num_threads();
-    ManyToMany<MyGraph, DijkstraManyToManyFW, DijkstraManyToManyBW,
-               performBucketScans> mtm(graph_thr, earlyStopLevel);
-    Matrix<EdgeWeight> matrix((NodeID)matr_cols[matr_id],
-                              (NodeID)sources.Size());
-    int offset = matr_id * thr_size;
-    vector<NodeID>::const_iterator first = v_sources.begin() + offset;
-    vector<NodeID>::const_iterator last =
-        v_sources.begin() + offset + matr_cols[matr_id];
-    vector<NodeID> newVec(first, last);
-
-    mtm.computeMatrix(newVec, v_sources, matrix);
-
-    int noOfRows = matrix.noOfRows();
-    int noOfCols = matrix.noOfCols();
-
-#pragma omp ordered
-    {
-      vector<string> names;
-      for (int j = 0; j < noOfRows; j++) {
-        rapidjson::Value result;
-        result.SetArray();
-        rapidjson::Document::AllocatorType& allocator = out_doc.GetAllocator();
-
-        for (int k = 0; k < noOfCols; k++) {
-          result.PushBack(matrix.value(j, k), allocator);
-        }
 
-        stringstream ss;
-        ss << j + matr_id* thr_size;
+  const int noOfThreads = threads > 0 ? threads : omp_get_max_threads();
 
-        string str = ss.str();
+#pragma omp parallel num_threads(noOfThreads)
+  {
+    DijkstraManyToManyFW dFW(graph);
 
-        names.push_back(str);
+#pragma omp for schedule(dynamic, 1)
+    for (int r = 0; r < (int)noOfRows; r++) {
+      for (NodeID c = 0; c < noOfCols; c++) {
+        unsigned int& cell = distances[(unsigned long long)r * noOfCols + c];
 
-        rapidjson::Value key;
-        key.SetString(names[j].c_str(), names[j].length(),
-                      out_doc.GetAllocator());
-        out_doc.AddMember(key, result, allocator);
+        dFW.clear();
+        if (dFW.bidirSearch(v_sources[r], v_targets[c]) == Weight::MAX_VALUE) {
+          cell = Weight::MAX_VALUE;
+          continue;
+        }
+        Path path;
+        dFW.pathTo(path, v_targets[c], -1, true, true);
+
+        // nodes without a position are skipped
+        double length = 0;
+        int prev = -1;
+        for (NodeID i = 0; i < path.noOfNodes(); i++) {
+          const NodeID u = g->mapIntToExtNodeID(path.node(i));
+          if (lats[u] != lats[u] || lons[u] != lons[u]) {
+            continue;
+          }
+          if (prev >= 0) {
+            length += haversine(lats[prev], lons[prev], lats[u], lons[u]);
+          }
+          prev = u;
+        }
+        cell = (unsigned int)(length + 0.5);
       }
     }
   }
-
-  rapidjson::StringBuffer strbuf;
-  rapidjson::Writer<rapidjson::StringBuffer> writer(strbuf);
-  out_doc.Accept(writer);
-
-  delete graph;
-
-  return strbuf.GetString();
 }
-*/
 
-const std::string calc_paths(const std::string& json_data,
-                             const std::string& country,
-                             const int speed_profile,
-                             const std::string& dataDir) {
+const std::string calc_paths(const Graph* g, const std::string& json_data) {
   rapidjson::Document d;
   LevelID earlyStopLevel = 10;
 
   const clock_t begin_time = clock();
 
-  MyGraph* graph;
+  MyGraph* graph = g->searchGraph();
 
-  try {
-    graph = loadGraph(country, speed_profile, dataDir);
+  d.Parse<0>(json_data.c_str());
+  if (d.HasParseError()) {
+    throw ParseError(std::string("paths request: ") + d.GetParseError());
   }
-  catch (std::invalid_argument& e) {
-    return "{}";
+  if (!d.IsArray()) {
+    throw ParseError("paths request is not a list");
   }
-
-  d.Parse<0>(json_data.c_str());
   // cout <<"Load and parse: "<<float( clock () - begin_time ) /  CLOCKS_PER_SEC
   // <<endl;
   rapidjson::Document out_doc;
@@ -247,8 +335,8 @@ const std::string calc_paths(const std::string& json_data,
     // <<endl;
 
     const rapidjson::Value& c = d[i];
-    NodeID source_id = mapNodeID(graph, (NodeID)c["source"].GetUint());
-    NodeID target_id = mapNodeID(graph, (NodeID)c["target"].GetUint());
+    NodeID source_id = mapNodeID(graph, parseNodeID(c, "source"));
+    NodeID target_id = mapNodeID(graph, parseNodeID(c, "target"));
 
     _dFW.clear();
     EdgeWeight w = _dFW.bidirSearch(source_id, target_id);
@@ -263,7 +351,8 @@ const std::string calc_paths(const std::string& json_data,
 
     if (num_edges > 0) {
       for (EdgeID e = 0; e <= num_edges; e++) {
-        result_internal.PushBack(a.node(e), out_doc.GetAllocator());
+        result_internal.PushBack(g->mapIntToExtNodeID(a.node(e)),
+                                 out_doc.GetAllocator());
       }
     }
 
@@ -281,7 +370,5 @@ const std::string calc_paths(const std::string& json_data,
   rapidjson::Writer<rapidjson::StringBuffer> writer(strbuf);
   out_doc.Accept(writer);
 
-  delete graph;
-
   return strbuf.GetString();
 }
@@ -1,4 +1,117 @@
 #pragma once
 
-const std::string calc_dm(const std::string& json_data, const std::string& country, const int speed_profile, const std::string& dataDir);
-const std::string calc_paths(const std::string& json_data, const std::string& country, const int speed_profile, const std::string& dataDir);
\ No newline at end of file
+#include <stdexcept>
+#include <string>
+#include <vector>
+
+namespace datastr { namespace graph { class SearchGraph; } }
+
+#ifndef SWIG
+/*
+ * Errors of the library. ch.swigcxx turns them into Go panics whose message
+ * starts with the kind of the error, which the Go side turns back into errors.
+ * Anything else thrown is reported as an internal error.
+ */
+class GraphNotFound : public std::runtime_error {
+public:
+  explicit GraphNotFound(const std::string& what) : std::runtime_error(what) {}
+};
+
+class ParseError : public std::runtime_error {
+public:
+  explicit ParseError(const std::string& what) : std::runtime_error(what) {}
+};
+#endif
+
+/*
+ * A contraction hierarchies graph loaded from a .sgr file. Queries keep
+ * their search state to themselves, so one Graph can be shared by any
+ * number of concurrent calc_dm and calc_paths calls.
+ */
+class Graph {
+public:
+  ~Graph();
+
+  unsigned int noOfNodes() const;
+  unsigned int noOfEdges() const;
+  // The memory used by the graph in bytes.
+  unsigned long long memoryUsage() const;
+
+#ifndef SWIG
+  explicit Graph(datastr::graph::SearchGraph* g);
+  datastr::graph::SearchGraph* searchGraph() const { return _g; }
+
+  // Maps the node IDs used inside the graph back to the IDs of the input.
+  unsigned int mapIntToExtNodeID(const unsigned int u) const { return _intToExt[u]; }
+
+private:
+  datastr::graph::SearchGraph* const _g;
+  std::vector<unsigned int> _intToExt;
+#endif
+};
+
+// Throws GraphNotFound if the file can not be read.
+Graph* load_graph(const std::string& path);
+
+// The size of a graph file.
+struct GraphFileInfo {
+  unsigned int noOfNodes;
+  unsigned int noOfEdges;
+};
+
+/*
+ * Reads the size of a graph file without loading the graph. Throws
+ * GraphNotFound if the file can not be read and ParseError if it is too
+ * short to be a graph.
+ */
+GraphFileInfo read_graph_info(const std::string& path);
+
+/*
+ * Computes the weights from every source to every target into weights, row
+ * by row; weights must hold noOfSources * noOfTargets values. Without targets
+ * the matrix is computed from sources to sources. threads <= 0 lets OpenMP
+ * decide how many threads to use, 1 computes sequentially.
+ */
+void calc_matrix(const Graph* graph,
+                 const unsigned int* sources, const int noOfSources,
+                 const unsigned int* targets, const int noOfTargets,
+                 unsigned int* weights, const int noOfWeights,
+                 const int threads);
+
+/*
+ * Computes the length in meters of the shortest path from every source to
+ * every target into distances, laid out like the weights of calc_matrix.
+ * lats and lons hold the coordinates of every node by its ID; the length is
+ * the sum of the great-circle distances between the nodes of the path.
+ * Pairs without a path get the maximum unsigned int.
+ */
+void calc_distances(const Graph* graph,
+                    const unsigned int* sources, const int noOfSources,
+                    const unsigned int* targets, const int noOfTargets,
+                    const float* lats, const int noOfLats,
+                    const float* lons, const int noOfLons,
+                    unsigned int* distances, const int noOfDistances,
+                    const int threads);
+
+/*
+ * Computes the rows of a matrix one source at a time. The backward searches
+ * from the targets are done once when it is created, so every row only costs
+ * a forward search and its bucket scans. Not safe for concurrent use.
+ */
+class MatrixRows {
+public:
+  MatrixRows(const Graph* graph, const unsigned int* targets, const int noOfTargets);
+  ~MatrixRows();
+
+  // Computes the weights from source to every target into weights.
+  void row(const unsigned int source, unsigned int* weights, const int noOfWeights);
+
+#ifndef SWIG
+private:
+  struct Impl;
+  Impl* _impl;
+#endif
+};
+
+// Throws ParseError if json_data is malformed.
+const std::string calc_paths(const Graph* graph, const std::string& json_data);
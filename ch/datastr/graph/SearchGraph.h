@@ -197,6 +197,13 @@ public:
         return _edges[e];
     }
 
+    /** Returns the memory used by the edges, the nodes and the node ID mapping in bytes. */
+    unsigned long long memoryUsage() const {
+        return (unsigned long long)noOfEdges() * sizeof(Edge)
+            + (unsigned long long)_nodes.size() * sizeof(SearchNode)
+            + (unsigned long long)_mapExtToIntNodeIDs.size() * sizeof(NodeID);
+    }
+
     /**
     * The nodes can have internally a differnt
     * node id to increase the performance (cache).
@@ -0,0 +1,71 @@
+package ch
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ErrorKind tells what went wrong in the CH library.
+type ErrorKind int
+
+const (
+	Internal ErrorKind = iota
+	GraphNotFound
+	ParseFailure
+)
+
+// The message prefixes set by the %exception handler in ch.swigcxx.
+var kindPrefixes = map[ErrorKind]string{
+	GraphNotFound: "graph not found: ",
+	ParseFailure:  "parse failure: ",
+	Internal:      "internal: ",
+}
+
+// Error is an exception thrown by the CH library.
+type Error struct {
+	Kind    ErrorKind
+	Message string
+}
+
+func (e *Error) Error() string {
+	return kindPrefixes[e.Kind] + e.Message
+}
+
+// recoverError turns a panic raised by the SWIG wrappers into an *Error.
+// It must be deferred directly by the function that calls the wrapper.
+func recoverError(err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	msg := fmt.Sprint(r)
+	for kind, prefix := range kindPrefixes {
+		if strings.HasPrefix(msg, prefix) {
+			*err = &Error{Kind: kind, Message: strings.TrimPrefix(msg, prefix)}
+			return
+		}
+	}
+	*err = &Error{Kind: Internal, Message: msg}
+}
+
+// LoadGraph loads the graph file at path.
+func LoadGraph(path string) (g Graph, err error) {
+	defer recoverError(&err)
+	return Load_graph(path), nil
+}
+
+// CalcPaths computes the paths of the JSON request on g.
+func CalcPaths(g Graph, request string) (res string, err error) {
+	defer recoverError(&err)
+	return Calc_paths(g, request), nil
+}
+
+// ReadGraphInfo returns the number of nodes and edges of the graph file at
+// path without loading it.
+func ReadGraphInfo(path string) (nodes, edges int, err error) {
+	defer recoverError(&err)
+	info := Read_graph_info(path)
+	defer DeleteGraphFileInfo(info)
+	return int(info.GetNoOfNodes()), int(info.GetNoOfEdges()), nil
+}
@@ -251,12 +251,54 @@ public:
         COUNTING( cout << "bucket scans (all): " << bucketScans << endl );
 	    //COUNTING( cout << "bucket scans (top): " << bucketScansTop << endl );
     }
+
+    /**
+     * Performs the backward searches from the targets, so that the rows of
+     * the matrix can be computed one source at a time by computeRow.
+     */
+    void prepareTargets(const vector<NodeID>& targets) {
+        _noOfTargets = targets.size();
+        for (NodeID v = 0; v < targets.size(); v++) {
+            setCurrentNode(v);
+            _dBW.bidirSearch(SPECIAL_NODEID, targets[v]);
+            _dBW.obtainRelevantSearchSpace(*this);
+            _dBW.clear();
+        }
+        _searchSpacesBwDynInt.sort(_g->noOfNodes());
+        _searchSpacesBwInt = _searchSpacesBwDynInt;
+    }
+
+    /**
+     * Computes the distances from s to the targets given to prepareTargets
+     * into row, which must hold a value for every target.
+     */
+    void computeRow(const NodeID s, EdgeWeight* row) {
+        for (NodeID v = 0; v < _noOfTargets; v++) row[v] = Weight::MAX_VALUE;
+
+        _dFW.bidirSearch(s, SPECIAL_NODEID);
+        _dFW.obtainRelevantSearchSpace(*this);
+        _dFW.clear();
+
+        for (NodeID i = 0; i < _searchSpaceFW.size(); i++) {
+            const NodeID via = _searchSpaceFW[i].target();
+            const EdgeWeight distFW = _searchSpaceFW[i].weight();
+
+            const ISSInt::const_iterator endInt = _searchSpacesBwInt.end(via);
+            for (ISSInt::const_iterator it = _searchSpacesBwInt.begin(via); it != endInt; it++) {
+                const EdgeWeight w = distFW + it->dist();
+                if (w < row[it->origin()]) row[it->origin()] = w;
+            }
+        }
+
+        _searchSpaceFW.clear();
+    }
     
 private:
     Graph *const _g;
     DijkstraFW _dFW;
     DijkstraBW _dBW;
     const LevelID _earlyStopLevel;
+    NodeID _noOfTargets;
 };
 
 #endif // MANYTOMANY_H
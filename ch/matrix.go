@@ -0,0 +1,58 @@
+package ch
+
+// Matrix computes the weights from every source to every target on g and
+// returns them row by row. Without targets the matrix is computed from
+// sources to sources. threads <= 0 lets OpenMP decide how many threads to
+// use, 1 computes sequentially.
+func Matrix(g Graph, sources, targets []uint32, threads int) (weights []uint32, err error) {
+	defer recoverError(&err)
+
+	cols := len(targets)
+	if cols == 0 {
+		cols = len(sources)
+	}
+	buf := make([]uint32, len(sources)*cols)
+	Calc_matrix(g, sources, targets, buf, threads)
+	return buf, nil
+}
+
+// Distances computes the length in meters of the shortest path from every
+// source to every target on g, laid out like the weights of Matrix. lats and
+// lons hold the coordinates of every node by its ID.
+func Distances(g Graph, sources, targets []uint32, lats, lons []float32, threads int) (distances []uint32, err error) {
+	defer recoverError(&err)
+
+	cols := len(targets)
+	if cols == 0 {
+		cols = len(sources)
+	}
+	buf := make([]uint32, len(sources)*cols)
+	Calc_distances(g, sources, targets, lats, lons, buf, threads)
+	return buf, nil
+}
+
+// Rows computes the rows of a matrix one source at a time, against targets
+// fixed when it is created. Rows is not safe for concurrent use and must be
+// closed to free its memory.
+type Rows struct {
+	rows MatrixRows
+}
+
+// NewRows does the work shared by all rows to targets on g.
+func NewRows(g Graph, targets []uint32) (r *Rows, err error) {
+	defer recoverError(&err)
+	return &Rows{rows: NewMatrixRows(g, targets)}, nil
+}
+
+// Row computes the weights from source to every target into weights, which
+// must hold a value for every target.
+func (r *Rows) Row(source uint32, weights []uint32) (err error) {
+	defer recoverError(&err)
+	r.rows.Row(uint(source), weights)
+	return nil
+}
+
+// Close frees the memory held by r.
+func (r *Rows) Close() {
+	DeleteMatrixRows(r.rows)
+}
@@ -103,12 +103,13 @@ public:
      */
     DijkstraCH(Graph* graph)
     : _graph(graph),
+      _pqElements(graph->noOfNodes(), 0),
       _upperBound(Weight::MAX_VALUE)
     {
         assert( (searchDirections >= 1) && (searchDirections <= 2) );
     }
 
-    DijkstraCH(const DijkstraCH& other):_graph(other._graph)
+    DijkstraCH(const DijkstraCH& other):_graph(other._graph), _pqElements(other._graph->noOfNodes(), 0)
     {
     assert( (searchDirections >= 1) && (searchDirections <= 2) );
     _upperBound = Weight::MAX_VALUE;
@@ -745,7 +746,7 @@ public:
     void obtainSearchSpace(int searchID, vector<SearchSpaceEdge>& searchSpace) const {
         for (NodeID i = 0; i < _settledNodes[searchID].size(); i++) {
             const NodeID v = _settledNodes[searchID][i];
-            const NodeID index = _graph->node(v).pqElement();
+            const NodeID index = pqElement(v);
             const EdgeWeight k = pqKey(searchID, index);
             const PQData& data = pqData(searchID, index);
             if (data.isStartNode()) continue;
@@ -768,7 +769,7 @@ public:
         const int searchID = (manyToManyMode == MTMM_FW) ? 0 : 1;
         for (NodeID i = 0; i < _settledNodes[searchID].size(); i++) {
             const NodeID u = _settledNodes[searchID][i];
-            const NodeID index = _graph->node(u).pqElement();
+            const NodeID index = pqElement(u);
             if (pqData(searchID, index).stalled()) continue;
             const EdgeWeight dist = pqKey(searchID, index);
             if (manyToManyMode == MTMM_FW) ss.addToSearchSpaceFW(u, dist);
@@ -782,15 +783,7 @@ public:
     * if the specified node is reached; returns 0, otherwise.
     */
     NodeID isReached(int searchID, NodeID vID) const {
-        return isReached(searchID, _graph->node(vID));
-    }  
-    
-    /** 
-    * Returns the index of the corresponding pq element
-    * if the specified node is reached; returns 0, otherwise.
-    */
-    NodeID isReached(int searchID, const MyNode& v) const {
-        NodeID index = v.pqElement();
+        NodeID index = pqElement(vID);
         if (index == 0) return 0;
         if (pqueue(searchID).isDummy(index)) return 0;
         return index;
@@ -801,7 +794,7 @@ public:
     * if the specified node is settled; returns 0, otherwise.
     */
     NodeID isSettled(int searchID, NodeID vID) const {
-        NodeID index = _graph->node(vID).pqElement();
+        NodeID index = pqElement(vID);
         if (index == 0) return 0;
         if (pqueue(searchID).isDummy(index)) return 0;
 
@@ -973,6 +966,15 @@ private:
     /** The graph. */
     Graph *const _graph;
 
+    /**
+    * For each node, the index of the pq element that represents it,
+    * or 0 if the node has not been reached.
+    * This used to live in the node data structure of the graph. Keeping it
+    * here means a search never writes to the graph, so several searches
+    * can run on the same graph concurrently.
+    */
+    vector<NodeID> _pqElements;
+
     /** One priority queue for each search direction. */
     PQueue _pq[searchDirections];
 
@@ -1011,6 +1013,12 @@ private:
     /** Used for path expansion. */
     stack< pair<NodeID,EdgeID> > _expandPathStack;
 
+    /** Returns the index of the pq element that represents the given node. */
+    NodeID pqElement(NodeID vID) const {
+        assert( vID < _pqElements.size() );
+        return _pqElements[vID];
+    }
+
     /** Returns a reference to the specified pqueue. */
     PQueue& pqueue(int searchID) {
         assert( (searchID >= 0) && (searchID < searchDirections) );
@@ -1036,10 +1044,10 @@ private:
     * @return the index of the pq element that represents the inserted node
     */
     NodeID insert(int searchID, EdgeWeight dist, NodeID nodeID) {
-        NodeID index = _graph->node(nodeID).pqElement();
+        NodeID index = pqElement(nodeID);
         if (index == 0) {
             index = pqueue(searchID).insert(dist);
-            _graph->node(nodeID).pqElement(index);
+            _pqElements[nodeID] = index;
             if (searchDirections == 2) pqueue(1-searchID).insertDummy();
         }
         else {
@@ -1140,7 +1148,7 @@ private:
     NodeID decreaseKey(int searchID, EdgeWeight newDist, NodeID v)
     {
         // retrieve the element that represents the given node v
-        const NodeID index = _graph->node(v).pqElement();
+        const NodeID index = pqElement(v);
         const EdgeWeight key = pqKey(searchID, index);
 
         // no improvement, no decreaseKey
@@ -1188,13 +1196,12 @@ private:
             Edge& edge = _graph->edge(parent.edgeID);
 
             NodeID vID = edge.target(); // the id of the target of the edge
-            MyNode& v = _graph->node(vID); // reference to the target v of the edge
             NodeID index = 0;
 
             if (stallOnDemand)
             {
                 // try to wake up a node v that can start a stalling process
-                index = isReached(searchID, v);
+                index = isReached(searchID, vID);
                 // Node v has to be the endpoint of an edge (u,v) that 
                 // points into the opposite direction
                 // (because then the edge (v,u) which is used in the stalling process points into
@@ -1229,7 +1236,7 @@ private:
 
             // perform the pqueue operation (insert or decreaseKey)
             NodeID e;
-            index = isReached(searchID, v);
+            index = isReached(searchID, vID);
             if (! index) {
                 // v has been unreached -> INSERT it
                 e = insert( searchID, newDist, vID );
@@ -1278,15 +1285,15 @@ private:
     */
     void clearNodeVector(int searchID, vector<NodeID>& nodeVector) {
         for (NodeID i = 0; i < nodeVector.size(); i++) {
-            _graph->node( nodeVector[i] ).pqElement(0);
+            _pqElements[ nodeVector[i] ] = 0;
         }       
         nodeVector.clear();
     }
 
     /**
-     * Clears the given search direction. Especially pqElement entry
-     * in the node data structure of the graph is cleared so the next
-     * Dijkstra search can use it and decide wheter a node isReached().
+     * Clears the given search direction. Especially the pqElement entries
+     * of the settled nodes are cleared so the next
+     * Dijkstra search can use them and decide wheter a node isReached().
      */
     void clear(int searchID) {
         assert( (searchID >= 0) && (searchID < searchDirections) );
@@ -1304,7 +1311,7 @@ private:
         return true; // deactivate this check
         // This takes a lot of time.
         for (NodeID u = 0; u < _graph->noOfNodes(); u++) {
-            if (_graph->node(u).pqElement() != 0) return false;
+            if (pqElement(u) != 0) return false;
         }
         return true;
     }
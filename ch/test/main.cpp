@@ -25,9 +25,27 @@ int main()
 
 	//jsonInput = "{\"source\":" + jsonInput_s + ",\"target\":" + jsonInput_t + "}";
 	//jsonInput = "[{\"source\":164932,\"target\":671334},{\"source\":634791,\"target\":419348},{\"source\":419348,\"target\":761772}]";
-        jsonInput = "{ \"sources\": [291068,592850,777848,756365,847393]}";
-    cout<<jsonInput<<endl;
+	const unsigned int sources[] = {291068,592850,777848,756365,847393};
+	const int n = sizeof(sources) / sizeof(sources[0]);
 	EdgeWeight path_len;
 	EdgeID num_edges;
-	cout<<calc_dm(jsonInput,"finland",100, "/var/lib/spp/ch/");
+	Graph* graph = load_graph("/var/lib/spp/ch/finland-100.sgr");
+	std::vector<unsigned int> seq(n * n), par(n * n);
+	calc_matrix(graph, sources, n, NULL, 0, &seq[0], n * n, 1);
+	calc_matrix(graph, sources, n, NULL, 0, &par[0], n * n, 0);
+	for (int i = 0; i < n; i++) {
+		for (int j = 0; j < n; j++) cout<<seq[i * n + j]<<" ";
+		cout<<endl;
+	}
+	cout<<"parallel result "<<(seq == par ? "matches" : "DIFFERS")<<endl;
+	{
+		MatrixRows rows(graph, sources, n);
+		std::vector<unsigned int> streamed(n * n);
+		for (int i = 0; i < n; i++) rows.row(sources[i], &streamed[i * n], n);
+		cout<<"streamed result "<<(seq == streamed ? "matches" : "DIFFERS")<<endl;
+	}
+	GraphFileInfo info = read_graph_info("/var/lib/spp/ch/finland-100.sgr");
+	bool sizesMatch = info.noOfNodes == graph->noOfNodes() && info.noOfEdges == graph->noOfEdges();
+	cout<<"graph file size "<<(sizesMatch ? "matches" : "DIFFERS")<<endl;
+	delete graph;
 }
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDurations(t *testing.T) {
+	v := &Via{WeightSeconds: 0.5}
+	m := Matrix{Rows: 1, Cols: 3, Weights: []uint32{0, 7, unreachable}}
+
+	got := v.Durations(m)
+	if want := []uint32{0, 4, unreachable}; !reflect.DeepEqual(got.Weights, want) {
+		t.Errorf("durations => %v, want %v", got.Weights, want)
+	}
+}
+
+func TestCachedDistances(t *testing.T) {
+	v := NewVia(false, false, 60, "")
+	m := Matrix{Rows: 1, Cols: 2, Weights: []uint32{1200, unreachable}}
+	v.cacheMatrix(matrixKey("distances", "finland", "100", []int{1}, []int{2, 3}), m)
+
+	// No graph is loaded, so the distances can only come from the cache.
+	got, err := v.ComputeDistances([]int{1}, []int{2, 3}, "finland", "100", false)
+	if err != nil || !reflect.DeepEqual(got, m) {
+		t.Errorf("ComputeDistances => %v, %v, want %v", got, err, m)
+	}
+}
@@ -11,29 +11,93 @@ type Error struct {
 	ErrorCode int    `json:"ErrorCode"`
 	Message   string `json:"Message"`
 	Cause     string `json:"Cause,omitempty"`
+	// Indices of the offending items of the request, if any.
+	Indices []int `json:"Indices,omitempty"`
+	// Status overrides the HTTP status of ErrorCode when set.
+	Status int `json:"-"`
 }
 
 // Internal errors. Not the user's fault.
 const (
 	ErrContractionHierarchies = 101
 	ErrMatrixComputation      = 102
+	ErrNodeCoordinates        = 103
+	ErrEncoding               = 104
+	ErrGeoDB                  = 105
+	ErrPathComputation        = 106
+	ErrShuttingDown           = 107
 )
 
 // External errors. User error.
 const (
-	ReqErrMatrixNotFound = 200
+	ReqErrMatrixNotFound         = 200
+	ReqErrCoordinatesOutOfBounds = 201
+	ReqErrInvalidJSON            = 202
+	ReqErrUnknownCountry         = 203
+	ReqErrBadSpeedProfile        = 204
+	ReqErrEmptyNodeList          = 205
+	ReqErrNodeOutOfRange         = 206
+	ReqErrUnreachable            = 207
+	ReqErrTooLarge               = 208
+	ReqErrAddressNotFound        = 209
+	ReqErrBadFormat              = 210
+	ReqErrNotAcceptable          = 211
+	ReqErrUnauthorized           = 212
+	ReqErrForbidden              = 213
+	ReqErrQuotaExceeded          = 214
 )
 
 var internalErrors = map[int]string{
 	ErrContractionHierarchies: "Error while using contraction hierarchies.",
 	ErrMatrixComputation:      "Error in matrix computation",
+	ErrNodeCoordinates:        "Error while reading node coordinates.",
+	ErrEncoding:               "Error while encoding the response.",
+	ErrGeoDB:                  "Error while querying the geographic database.",
+	ErrPathComputation:        "Error in path computation.",
+	ErrShuttingDown:           "Server is shutting down.",
 }
 
-var requestErrors = map[int]string{}
+var requestErrors = map[int]string{
+	ReqErrMatrixNotFound:         "Matrix not found.",
+	ReqErrCoordinatesOutOfBounds: "Coordinates outside of the country.",
+	ReqErrInvalidJSON:            "Request body is not valid JSON.",
+	ReqErrUnknownCountry:         "Unknown country.",
+	ReqErrBadSpeedProfile:        "Speed profile not supported.",
+	ReqErrEmptyNodeList:          "No nodes given.",
+	ReqErrNodeOutOfRange:         "Node ID out of range.",
+	ReqErrUnreachable:            "No route between source and target.",
+	ReqErrTooLarge:               "Request too large.",
+	ReqErrAddressNotFound:        "Address not found.",
+	ReqErrBadFormat:              "Unknown result format.",
+	ReqErrNotAcceptable:          "Result can't be encoded as requested.",
+	ReqErrUnauthorized:           "Valid API key required.",
+	ReqErrForbidden:              "Not allowed for this API key.",
+	ReqErrQuotaExceeded:          "API key quota exceeded.",
+}
 
 var statusCodes = map[int]int{
-	ErrContractionHierarchies: http.StatusInternalServerError,
-	ErrMatrixComputation:      http.StatusInternalServerError,
+	ErrContractionHierarchies:    http.StatusInternalServerError,
+	ErrMatrixComputation:         http.StatusInternalServerError,
+	ErrNodeCoordinates:           http.StatusInternalServerError,
+	ErrEncoding:                  http.StatusInternalServerError,
+	ErrGeoDB:                     http.StatusInternalServerError,
+	ErrPathComputation:           http.StatusInternalServerError,
+	ErrShuttingDown:              http.StatusServiceUnavailable,
+	ReqErrMatrixNotFound:         http.StatusNotFound,
+	ReqErrCoordinatesOutOfBounds: 422,
+	ReqErrInvalidJSON:            http.StatusBadRequest,
+	ReqErrUnknownCountry:         422,
+	ReqErrBadSpeedProfile:        422,
+	ReqErrEmptyNodeList:          http.StatusBadRequest,
+	ReqErrNodeOutOfRange:         422,
+	ReqErrUnreachable:            422,
+	ReqErrTooLarge:               http.StatusRequestEntityTooLarge,
+	ReqErrAddressNotFound:        422,
+	ReqErrBadFormat:              http.StatusBadRequest,
+	ReqErrNotAcceptable:          http.StatusNotAcceptable,
+	ReqErrUnauthorized:           http.StatusUnauthorized,
+	ReqErrForbidden:              http.StatusForbidden,
+	ReqErrQuotaExceeded:          http.StatusTooManyRequests,
 }
 
 // NewError creates a new error.
@@ -54,6 +118,20 @@ func NewRequestError(errorCode int, cause string) *Error {
 	}
 }
 
+// NewIndexedRequestError creates a new request error that points at the
+// offending items of the request by their indices.
+func NewIndexedRequestError(errorCode int, cause string, indices []int) *Error {
+	e := NewRequestError(errorCode, cause)
+	e.Indices = indices
+	return e
+}
+
+// NewOutOfBoundsError creates a new error for the coordinates at indices of
+// list, which are outside of country.
+func NewOutOfBoundsError(list, country string, indices []int) *Error {
+	return NewIndexedRequestError(ReqErrCoordinatesOutOfBounds, "coordinates of "+list+" outside of "+country, indices)
+}
+
 // Error formats the error.
 func (e Error) Error() string {
 	return e.Message + " (" + e.Cause + ")"
@@ -65,6 +143,9 @@ func (e Error) toJSONString() string {
 }
 
 func (e Error) statusCode() int {
+	if e.Status != 0 {
+		return e.Status
+	}
 	status, ok := statusCodes[e.ErrorCode]
 	if !ok {
 		status = http.StatusBadRequest
@@ -0,0 +1,36 @@
+package error
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	tests := []struct {
+		err    *Error
+		status int
+	}{
+		{NewRequestError(ReqErrInvalidJSON, "unexpected EOF"), 400},
+		{NewRequestError(ReqErrUnknownCountry, "narnia"), 422},
+		{NewRequestError(ReqErrTooLarge, "too many cells"), 413},
+		{NewIndexedRequestError(ReqErrUnreachable, "no route", []int{1, 3}), 422},
+		{NewError(ErrContractionHierarchies, "graph not loaded"), 500},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		tt.err.WriteTo(w)
+		if w.Code != tt.status {
+			t.Errorf("error %d => status %d, want %d", tt.err.ErrorCode, w.Code, tt.status)
+		}
+
+		var got Error
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("error %d => invalid JSON: %s", tt.err.ErrorCode, err)
+		}
+		if got.ErrorCode != tt.err.ErrorCode || got.Message == "" || len(got.Indices) != len(tt.err.Indices) {
+			t.Errorf("error %d => %+v, want %+v", tt.err.ErrorCode, got, *tt.err)
+		}
+	}
+}
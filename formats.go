@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	viaErr "github.com/nfleet/via/error"
+)
+
+// Media types of matrix results, see encodeResult.
+const (
+	MediaJSON    = "application/json"
+	MediaBinary  = "application/vnd.via.matrix"
+	MediaMsgpack = "application/x-msgpack"
+	MediaNDJSON  = "application/x-ndjson"
+)
+
+// The media types a result can be encoded as, in order of preference.
+var resultMedia = []string{MediaJSON, MediaBinary, MediaMsgpack, MediaNDJSON}
+
+var mediaAliases = map[string]string{
+	"application/msgpack": MediaMsgpack,
+}
+
+// Returns the media type of a result that the Accept header asks for, or ""
+// if the header allows none of them. Without the header, results are JSON.
+func negotiateMedia(accept string) string {
+	if strings.TrimSpace(accept) == "" {
+		return MediaJSON
+	}
+
+	type choice struct {
+		media string
+		q     float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		media := strings.ToLower(strings.TrimSpace(params[0]))
+		if alias, ok := mediaAliases[media]; ok {
+			media = alias
+		}
+		q := 1.0
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				if v, err := strconv.ParseFloat(p[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			choices = append(choices, choice{media, q})
+		}
+	}
+	sort.SliceStable(choices, func(i, j int) bool {
+		return choices[i].q > choices[j].q
+	})
+
+	for _, c := range choices {
+		switch c.media {
+		case "*/*", "application/*":
+			return MediaJSON
+		}
+		for _, media := range resultMedia {
+			if c.media == media {
+				return media
+			}
+		}
+	}
+	return ""
+}
+
+// Encodes result as media, which is one of the result media types.
+//
+// JSON is the Result as is. The other formats always hold the matrices as
+// rows in source order, whatever the requested format. MessagePack is a map
+// with the keys of the JSON form. The binary format, all little-endian, is
+//
+//	"VIAM"                       magic
+//	uint32                       version, 1
+//	uint32                       rows
+//	uint32                       columns
+//	uint32                       matrices present, 1 weights | 2 durations | 4 distances
+//	rows * columns uint32 each   the present matrices in that order, row by row
+func encodeResult(result *Result, media string) ([]byte, error) {
+	switch media {
+	case MediaBinary:
+		return encodeBinary(result), nil
+	case MediaMsgpack:
+		return encodeMsgpack(result), nil
+	case MediaNDJSON:
+		return encodeNDJSON(result)
+	}
+	return json.Marshal(result)
+}
+
+// ndjsonHeader is the first line of an NDJSON result.
+type ndjsonHeader struct {
+	Rows           int           `json:"rows"`
+	Cols           int           `json:"cols"`
+	SpeedProfile   SpeedProfile  `json:"speed_profile"`
+	SnappedSources []SnappedNode `json:"snapped_sources,omitempty"`
+	SnappedTargets []SnappedNode `json:"snapped_targets,omitempty"`
+}
+
+// ndjsonRow is a row of an NDJSON result.
+type ndjsonRow struct {
+	Row       int      `json:"row"`
+	Weights   []uint32 `json:"weights"`
+	Durations []uint32 `json:"durations,omitempty"`
+	Distances []uint32 `json:"distances,omitempty"`
+}
+
+// ndjsonError replaces the row of a streamed NDJSON result that failed to
+// compute, and ends the result.
+type ndjsonError struct {
+	Error *viaErr.Error `json:"error"`
+}
+
+func encodeNDJSON(result *Result) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+
+	m := result.weights
+	header := ndjsonHeader{m.Rows, m.Cols, result.SpeedProfile, result.SnappedSources, result.SnappedTargets}
+	if err := enc.Encode(header); err != nil {
+		return nil, err
+	}
+	for i := 0; i < m.Rows; i++ {
+		row := ndjsonRow{Row: i, Weights: m.Row(i)}
+		if result.durations != nil {
+			row.Durations = result.durations.Row(i)
+		}
+		if result.distances != nil {
+			row.Distances = result.distances.Row(i)
+		}
+		if err := enc.Encode(row); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeBinary(result *Result) []byte {
+	matrices := []*Matrix{result.weights, result.durations, result.distances}
+
+	var present uint32
+	size := 20
+	for i, m := range matrices {
+		if m != nil {
+			present |= 1 << uint(i)
+			size += 4 * len(m.Weights)
+		}
+	}
+
+	buf := make([]byte, size)
+	copy(buf, "VIAM")
+	binary.LittleEndian.PutUint32(buf[4:], 1)
+	binary.LittleEndian.PutUint32(buf[8:], uint32(result.weights.Rows))
+	binary.LittleEndian.PutUint32(buf[12:], uint32(result.weights.Cols))
+	binary.LittleEndian.PutUint32(buf[16:], present)
+
+	off := 20
+	for _, m := range matrices {
+		if m == nil {
+			continue
+		}
+		for _, w := range m.Weights {
+			binary.LittleEndian.PutUint32(buf[off:], w)
+			off += 4
+		}
+	}
+	return buf
+}
+
+func encodeMsgpack(result *Result) []byte {
+	var w msgpackWriter
+
+	fields := 3
+	for _, present := range []bool{result.durations != nil, result.distances != nil, len(result.SnappedSources) > 0, len(result.SnappedTargets) > 0} {
+		if present {
+			fields++
+		}
+	}
+
+	w.mapHeader(fields)
+	w.string("progress")
+	w.string(result.Progress)
+	w.string("speed_profile")
+	if n, err := strconv.Atoi(string(result.SpeedProfile)); err == nil && strconv.Itoa(n) == string(result.SpeedProfile) {
+		w.int(int64(n))
+	} else {
+		w.string(string(result.SpeedProfile))
+	}
+	w.string("matrix")
+	w.matrix(result.weights)
+	if result.durations != nil {
+		w.string("durations")
+		w.matrix(result.durations)
+	}
+	if result.distances != nil {
+		w.string("distances")
+		w.matrix(result.distances)
+	}
+	if len(result.SnappedSources) > 0 {
+		w.string("snapped_sources")
+		w.snapped(result.SnappedSources)
+	}
+	if len(result.SnappedTargets) > 0 {
+		w.string("snapped_targets")
+		w.snapped(result.SnappedTargets)
+	}
+	return w.Bytes()
+}
+
+// msgpackWriter writes the few MessagePack types results need.
+type msgpackWriter struct {
+	bytes.Buffer
+}
+
+func (w *msgpackWriter) header(fix, fixMax byte, code8, code16, code32 byte, n int) {
+	switch {
+	case fix != 0 && n <= int(fixMax):
+		w.WriteByte(fix | byte(n))
+	case code8 != 0 && n <= math.MaxUint8:
+		w.WriteByte(code8)
+		w.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		w.WriteByte(code16)
+		binary.Write(w, binary.BigEndian, uint16(n))
+	default:
+		w.WriteByte(code32)
+		binary.Write(w, binary.BigEndian, uint32(n))
+	}
+}
+
+func (w *msgpackWriter) mapHeader(n int) {
+	w.header(0x80, 15, 0, 0xde, 0xdf, n)
+}
+
+func (w *msgpackWriter) arrayHeader(n int) {
+	w.header(0x90, 15, 0, 0xdc, 0xdd, n)
+}
+
+func (w *msgpackWriter) string(s string) {
+	w.header(0xa0, 31, 0xd9, 0xda, 0xdb, len(s))
+	w.WriteString(s)
+}
+
+func (w *msgpackWriter) uint(v uint64) {
+	switch {
+	case v <= 0x7f:
+		w.WriteByte(byte(v))
+	case v <= math.MaxUint8:
+		w.WriteByte(0xcc)
+		w.WriteByte(byte(v))
+	case v <= math.MaxUint16:
+		w.WriteByte(0xcd)
+		binary.Write(w, binary.BigEndian, uint16(v))
+	case v <= math.MaxUint32:
+		w.WriteByte(0xce)
+		binary.Write(w, binary.BigEndian, uint32(v))
+	default:
+		w.WriteByte(0xcf)
+		binary.Write(w, binary.BigEndian, v)
+	}
+}
+
+func (w *msgpackWriter) int(v int64) {
+	if v >= 0 {
+		w.uint(uint64(v))
+		return
+	}
+	w.WriteByte(0xd3)
+	binary.Write(w, binary.BigEndian, v)
+}
+
+func (w *msgpackWriter) float(v float64) {
+	w.WriteByte(0xcb)
+	binary.Write(w, binary.BigEndian, v)
+}
+
+func (w *msgpackWriter) matrix(m *Matrix) {
+	w.arrayHeader(m.Rows)
+	for i := 0; i < m.Rows; i++ {
+		row := m.Row(i)
+		w.arrayHeader(len(row))
+		for _, v := range row {
+			w.uint(uint64(v))
+		}
+	}
+}
+
+func (w *msgpackWriter) snapped(nodes []SnappedNode) {
+	w.arrayHeader(len(nodes))
+	for _, n := range nodes {
+		w.mapHeader(3)
+		w.string("node")
+		w.int(int64(n.Node))
+		w.string("coord")
+		w.arrayHeader(len(n.Coord))
+		for _, c := range n.Coord {
+			w.float(c)
+		}
+		w.string("distance")
+		w.float(n.Distance)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestNegotiateMedia(t *testing.T) {
+	tests := []struct {
+		accept, want string
+	}{
+		{"", MediaJSON},
+		{"*/*", MediaJSON},
+		{"application/json", MediaJSON},
+		{"application/vnd.via.matrix", MediaBinary},
+		{"application/msgpack", MediaMsgpack},
+		{"text/html, application/x-msgpack;q=0.9, application/json;q=0.5", MediaMsgpack},
+		{"application/vnd.via.matrix;q=0, application/json", MediaJSON},
+		{"text/html", ""},
+	}
+
+	for _, tt := range tests {
+		if got := negotiateMedia(tt.accept); got != tt.want {
+			t.Errorf("negotiateMedia(%q) => %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeBinary(t *testing.T) {
+	weights := Matrix{Rows: 2, Cols: 2, Weights: []uint32{0, 1, 2, 3}}
+	distances := Matrix{Rows: 2, Cols: 2, Weights: []uint32{0, 10, 20, unreachable}}
+
+	res, err := encodeResult(&Result{weights: &weights, distances: &distances}, MediaBinary)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []uint32{1, 2, 2, 5, 0, 1, 2, 3, 0, 10, 20, unreachable}
+	if len(res) != 4+4*len(want) || string(res[:4]) != "VIAM" {
+		t.Fatalf("binary result of %d bytes starting with %q", len(res), res[:4])
+	}
+	for i, w := range want {
+		if got := binary.LittleEndian.Uint32(res[4+4*i:]); got != w {
+			t.Errorf("word %d => %d, want %d", i, got, w)
+		}
+	}
+}
+
+func TestEncodeMsgpack(t *testing.T) {
+	weights := Matrix{Rows: 1, Cols: 2, Weights: []uint32{5, 300}}
+
+	res, err := encodeResult(&Result{Progress: "complete", SpeedProfile: "100", weights: &weights}, MediaMsgpack)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want bytes.Buffer
+	want.WriteByte(0x83)
+	want.WriteString("\xa8progress\xa8complete")
+	want.WriteString("\xadspeed_profile\x64")
+	want.WriteString("\xa6matrix\x91\x92\x05\xcd\x01\x2c")
+	if !bytes.Equal(res, want.Bytes()) {
+		t.Errorf("msgpack result => %x, want %x", res, want.Bytes())
+	}
+}
+
+func TestEncodeNDJSON(t *testing.T) {
+	weights := Matrix{Rows: 2, Cols: 2, Weights: []uint32{0, 1, 2, 3}}
+
+	res, err := encodeResult(&Result{SpeedProfile: "100", weights: &weights}, MediaNDJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"rows":2,"cols":2,"speed_profile":100}
+{"row":0,"weights":[0,1]}
+{"row":1,"weights":[2,3]}
+`
+	if string(res) != want {
+		t.Errorf("NDJSON result =>\n%s\nwant\n%s", res, want)
+	}
+}
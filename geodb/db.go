@@ -0,0 +1,106 @@
+package geodb
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/nfleet/via/geotypes"
+)
+
+var _ geotypes.GeoDB = (*DB)(nil)
+
+// DB implements geotypes.GeoDB on top of the files in the data directory, so
+// no database server is needed.
+type DB struct {
+	store     *Store
+	countries map[string]bool
+}
+
+// NewDB creates a GeoDB serving the given countries from store.
+func NewDB(store *Store, countries map[string]bool) *DB {
+	return &DB{store: store, countries: countries}
+}
+
+func (db *DB) QueryClosestPoint(point geotypes.Coord, country string) (geotypes.CHNode, error) {
+	nodes, err := db.store.Nodes(country)
+	if err != nil {
+		return geotypes.CHNode{}, err
+	}
+	node, _, err := nodes.Nearest(point)
+	return node, err
+}
+
+func (db *DB) QueryCoordinates(nodes []int, country string) ([]geotypes.Coord, error) {
+	all, err := db.store.Nodes(country)
+	if err != nil {
+		return nil, err
+	}
+
+	coords := make([]geotypes.Coord, len(nodes))
+	for i, node := range nodes {
+		coord, ok := all.Coord(node)
+		if !ok {
+			return nil, fmt.Errorf("node %d has no coordinates", node)
+		}
+		coords[i] = coord
+	}
+	return coords, nil
+}
+
+// QueryFuzzyAddress matches the street and city of address against the street
+// index of the country of the address.
+func (db *DB) QueryFuzzyAddress(address geotypes.Address, count int) ([]geotypes.Location, error) {
+	country := normalize(address.Country)
+	if !db.countries[country] {
+		return nil, fmt.Errorf("country %q is not served", address.Country)
+	}
+
+	streets, err := db.store.Streets(country)
+	if err != nil {
+		return nil, err
+	}
+	return streets.Search(address, count), nil
+}
+
+// QueryDistance returns the length in meters of the polyline through nodes.
+func (db *DB) QueryDistance(nodes []int, country string) (int, error) {
+	coords, err := db.QueryCoordinates(nodes, country)
+	if err != nil {
+		return 0, err
+	}
+
+	distance := 0.0
+	for i := 1; i < len(coords); i++ {
+		distance += Distance(coords[i-1], coords[i])
+	}
+	return int(distance + 0.5), nil
+}
+
+// QueryStatus checks that the data directory can be read and that the node
+// coordinates and street index of every country are present.
+func (db *DB) QueryStatus() error {
+	dir, err := os.Open(db.store.dataDir)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	if _, err := dir.Readdirnames(1); err != nil {
+		return fmt.Errorf("data directory %s can not be read: %s", db.store.dataDir, err.Error())
+	}
+
+	countries := make([]string, 0, len(db.countries))
+	for country := range db.countries {
+		countries = append(countries, country)
+	}
+	sort.Strings(countries)
+
+	for _, country := range countries {
+		for _, path := range []string{NodesFile(db.store.dataDir, country), StreetsFile(db.store.dataDir, country)} {
+			if _, err := os.Stat(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package geodb
+
+import "math"
+
+// kdTree is a static 3-d tree over graph nodes. The coordinates are stored
+// as points on the unit sphere, where the straight-line distance grows with the
+// great-circle distance, so the nearest point in the tree is the nearest node.
+// float32 keeps the points within a meter of their position at half the memory.
+type kdTree struct {
+	ids []int32
+	pts [][3]float32
+}
+
+func newKDTree(ids []int32, lats, lons []float32) *kdTree {
+	t := &kdTree{
+		ids: ids,
+		pts: make([][3]float32, len(ids)),
+	}
+	for i := range ids {
+		t.pts[i] = toPoint(float64(lats[i]), float64(lons[i]))
+	}
+
+	t.build(0, len(ids), 0)
+	return t
+}
+
+func toPoint(lat, lon float64) [3]float32 {
+	lat, lon = lat*math.Pi/180, lon*math.Pi/180
+	return [3]float32{
+		float32(math.Cos(lat) * math.Cos(lon)),
+		float32(math.Cos(lat) * math.Sin(lon)),
+		float32(math.Sin(lat)),
+	}
+}
+
+func (t *kdTree) axis(i, depth int) float32 {
+	return t.pts[i][depth%3]
+}
+
+func (t *kdTree) swap(i, j int) {
+	t.ids[i], t.ids[j] = t.ids[j], t.ids[i]
+	t.pts[i], t.pts[j] = t.pts[j], t.pts[i]
+}
+
+// build arranges entries lo..hi so that the median along the axis of depth
+// sits in the middle, with the smaller entries before it and the larger after.
+func (t *kdTree) build(lo, hi, depth int) {
+	if hi-lo <= 1 {
+		return
+	}
+	mid := (lo + hi) / 2
+	t.selectNth(lo, hi, mid, depth)
+	t.build(lo, mid, depth+1)
+	t.build(mid+1, hi, depth+1)
+}
+
+// selectNth partially sorts lo..hi so that n holds the entry that would be
+// there if the range was sorted along the axis of depth.
+func (t *kdTree) selectNth(lo, hi, n, depth int) {
+	for hi-lo > 1 {
+		// median of three as pivot, moved to the end
+		mid := (lo + hi) / 2
+		if t.axis(mid, depth) < t.axis(lo, depth) {
+			t.swap(mid, lo)
+		}
+		if t.axis(hi-1, depth) < t.axis(lo, depth) {
+			t.swap(hi-1, lo)
+		}
+		if t.axis(mid, depth) < t.axis(hi-1, depth) {
+			t.swap(mid, hi-1)
+		}
+		pivot := t.axis(hi-1, depth)
+
+		store := lo
+		for i := lo; i < hi-1; i++ {
+			if t.axis(i, depth) < pivot {
+				t.swap(i, store)
+				store++
+			}
+		}
+		t.swap(store, hi-1)
+
+		switch {
+		case n == store:
+			return
+		case n < store:
+			hi = store
+		default:
+			lo = store + 1
+		}
+	}
+}
+
+// nearest returns the id of the node closest to the given coordinate, or
+// false if the tree is empty.
+func (t *kdTree) nearest(lat, lon float64) (int32, bool) {
+	if len(t.ids) == 0 {
+		return 0, false
+	}
+	p := toPoint(lat, lon)
+	best, bestDist := -1, math.Inf(1)
+	t.search(0, len(t.ids), 0, p, &best, &bestDist)
+	return t.ids[best], true
+}
+
+func (t *kdTree) search(lo, hi, depth int, p [3]float32, best *int, bestDist *float64) {
+	if lo >= hi {
+		return
+	}
+	mid := (lo + hi) / 2
+
+	var d float64
+	for k := 0; k < 3; k++ {
+		diff := float64(t.pts[mid][k] - p[k])
+		d += diff * diff
+	}
+	if d < *bestDist {
+		*best, *bestDist = mid, d
+	}
+
+	// diff > 0 means the query lies before the splitting entry
+	diff := float64(t.axis(mid, depth) - p[depth%3])
+	if diff > 0 {
+		t.search(lo, mid, depth+1, p, best, bestDist)
+		if diff*diff < *bestDist {
+			t.search(mid+1, hi, depth+1, p, best, bestDist)
+		}
+	} else {
+		t.search(mid+1, hi, depth+1, p, best, bestDist)
+		if diff*diff < *bestDist {
+			t.search(lo, mid, depth+1, p, best, bestDist)
+		}
+	}
+}
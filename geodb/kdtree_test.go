@@ -0,0 +1,66 @@
+package geodb
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/nfleet/via/geotypes"
+)
+
+func TestNearestMatchesLinearScan(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	n := 5000
+	ids := make([]int32, n)
+	lats := make([]float32, n)
+	lons := make([]float32, n)
+	for i := range ids {
+		ids[i] = int32(i)
+		lats[i] = float32(59.8 + r.Float64()*10.3)
+		lons[i] = float32(20.5 + r.Float64()*11.1)
+	}
+	nodes := &Nodes{lats: append([]float32{}, lats...), lons: append([]float32{}, lons...)}
+	nodes.tree = newKDTree(ids, lats, lons)
+
+	for q := 0; q < 200; q++ {
+		point := geotypes.Coord{59.8 + r.Float64()*10.3, 20.5 + r.Float64()*11.1}
+
+		node, dist, err := nodes.Nearest(point)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		best := -1
+		for i := 0; i < n; i++ {
+			c, _ := nodes.Coord(i)
+			if best < 0 || Distance(point, c) < Distance(point, mustCoord(nodes, best)) {
+				best = i
+			}
+		}
+
+		if node.Id != best {
+			t.Errorf("Nearest(%v) => %d at %.1f m, linear scan found %d at %.1f m",
+				point, node.Id, dist, best, Distance(point, mustCoord(nodes, best)))
+		}
+	}
+}
+
+func mustCoord(nodes *Nodes, id int) geotypes.Coord {
+	c, _ := nodes.Coord(id)
+	return c
+}
+
+func TestDistance(t *testing.T) {
+	// Helsinki to Tampere is roughly 160 km as the crow flies.
+	d := Distance(geotypes.Coord{60.1699, 24.9384}, geotypes.Coord{61.4978, 23.7610})
+	if d < 155000 || d > 165000 {
+		t.Errorf("Distance(Helsinki, Tampere) => %.0f m, want about 160 km", d)
+	}
+}
+
+func TestNearestOnEmptyTree(t *testing.T) {
+	nodes := &Nodes{tree: newKDTree(nil, nil, nil)}
+	if _, _, err := nodes.Nearest(geotypes.Coord{60, 25}); err == nil {
+		t.Error("Nearest on an empty tree should fail")
+	}
+}
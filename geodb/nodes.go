@@ -0,0 +1,112 @@
+// Package geodb provides the geographic data via needs next to the
+// contraction hierarchies graphs, read from files in the data directory.
+package geodb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+
+	"github.com/nfleet/via/geotypes"
+)
+
+const earthRadius = 6371000.0
+
+// NodesFile returns the path of the node coordinate file of country.
+// The file holds one little-endian float32 latitude/longitude pair per graph
+// node, indexed by the node ID. Nodes without a position have NaN coordinates.
+func NodesFile(dataDir, country string) string {
+	return filepath.Join(dataDir, country+".coords")
+}
+
+// Nodes holds the coordinates of the graph nodes of one country and a
+// spatial index over them.
+type Nodes struct {
+	lats, lons []float32
+	tree       *kdTree
+}
+
+func LoadNodes(path string) (*Nodes, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(contents)%8 != 0 {
+		return nil, fmt.Errorf("%s is not a node coordinate file, size %d is not a multiple of 8", path, len(contents))
+	}
+
+	n := len(contents) / 8
+	nodes := &Nodes{
+		lats: make([]float32, n),
+		lons: make([]float32, n),
+	}
+
+	var ids []int32
+	var lats, lons []float32
+	for i := 0; i < n; i++ {
+		lat := math.Float32frombits(binary.LittleEndian.Uint32(contents[8*i:]))
+		lon := math.Float32frombits(binary.LittleEndian.Uint32(contents[8*i+4:]))
+		nodes.lats[i], nodes.lons[i] = lat, lon
+
+		if isNaN(lat) || isNaN(lon) {
+			continue
+		}
+		ids = append(ids, int32(i))
+		lats = append(lats, lat)
+		lons = append(lons, lon)
+	}
+
+	nodes.tree = newKDTree(ids, lats, lons)
+	return nodes, nil
+}
+
+func isNaN(f float32) bool {
+	return math.IsNaN(float64(f))
+}
+
+// Len returns the number of nodes.
+func (n *Nodes) Len() int {
+	return len(n.lats)
+}
+
+// Positions returns the latitudes and longitudes of all nodes by their ID.
+// The slices are shared and must not be modified.
+func (n *Nodes) Positions() (lats, lons []float32) {
+	return n.lats, n.lons
+}
+
+// Coord returns the coordinates of node id, or false if the node is unknown
+// or has no position.
+func (n *Nodes) Coord(id int) (geotypes.Coord, bool) {
+	if id < 0 || id >= len(n.lats) || isNaN(n.lats[id]) || isNaN(n.lons[id]) {
+		return nil, false
+	}
+	return geotypes.Coord{float64(n.lats[id]), float64(n.lons[id])}, true
+}
+
+// Nearest returns the node closest to point and its distance in meters.
+func (n *Nodes) Nearest(point geotypes.Coord) (geotypes.CHNode, float64, error) {
+	if len(point) != 2 {
+		return geotypes.CHNode{}, 0, fmt.Errorf("point %v is not a [latitude, longitude] pair", point)
+	}
+
+	id, ok := n.tree.nearest(point[0], point[1])
+	if !ok {
+		return geotypes.CHNode{}, 0, fmt.Errorf("no nodes to snap to")
+	}
+
+	coord, _ := n.Coord(int(id))
+	return geotypes.CHNode{Id: int(id), Coord: coord}, Distance(point, coord), nil
+}
+
+// Distance returns the great-circle distance between a and b in meters.
+func Distance(a, b geotypes.Coord) float64 {
+	lat1, lat2 := a[0]*math.Pi/180, b[0]*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (b[1] - a[1]) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
@@ -0,0 +1,86 @@
+package geodb
+
+import "sync"
+
+type entry struct {
+	ready chan struct{}
+	value interface{}
+	err   error
+}
+
+// lazyFiles loads a file per country on first use and keeps the result.
+type lazyFiles struct {
+	load func(country string) (interface{}, error)
+
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+func (l *lazyFiles) get(country string) (interface{}, error) {
+	l.mu.Lock()
+	e, ok := l.entries[country]
+	if ok {
+		l.mu.Unlock()
+		<-e.ready
+		return e.value, e.err
+	}
+	e = &entry{ready: make(chan struct{})}
+	l.entries[country] = e
+	l.mu.Unlock()
+
+	e.value, e.err = l.load(country)
+	if e.err != nil {
+		// Forget failed loads so that a file added later gets picked up.
+		l.mu.Lock()
+		delete(l.entries, country)
+		l.mu.Unlock()
+	}
+	close(e.ready)
+
+	return e.value, e.err
+}
+
+// Store loads the node coordinates and street index of each country on first
+// use and keeps them in memory.
+type Store struct {
+	dataDir string
+
+	nodes   *lazyFiles
+	streets *lazyFiles
+}
+
+func NewStore(dataDir string) *Store {
+	return &Store{
+		dataDir: dataDir,
+		nodes: &lazyFiles{
+			entries: make(map[string]*entry),
+			load: func(country string) (interface{}, error) {
+				return LoadNodes(NodesFile(dataDir, country))
+			},
+		},
+		streets: &lazyFiles{
+			entries: make(map[string]*entry),
+			load: func(country string) (interface{}, error) {
+				return LoadStreets(StreetsFile(dataDir, country))
+			},
+		},
+	}
+}
+
+// Nodes returns the nodes of country.
+func (s *Store) Nodes(country string) (*Nodes, error) {
+	v, err := s.nodes.get(country)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*Nodes), nil
+}
+
+// Streets returns the street index of country.
+func (s *Store) Streets(country string) (*Streets, error) {
+	v, err := s.streets.get(country)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*Streets), nil
+}
@@ -0,0 +1,193 @@
+package geodb
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nfleet/via/geotypes"
+)
+
+// StreetsFile returns the path of the street index of country. Every line of
+// the file is a tab separated record of street, city, postal code, latitude
+// and longitude.
+func StreetsFile(dataDir, country string) string {
+	return filepath.Join(dataDir, country+".streets")
+}
+
+type street struct {
+	name, city, postalCode string
+	key, cityKey           string
+	lat, lon               float64
+}
+
+// Streets is a street/city index of one country.
+type Streets struct {
+	streets []street
+	byCity  map[string][]int
+}
+
+func LoadStreets(path string) (*Streets, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := &Streets{byCity: make(map[string][]int)}
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 5 {
+			return nil, fmt.Errorf("%s:%d: want 5 tab separated fields, got %d", path, line, len(fields))
+		}
+		lat, err := strconv.ParseFloat(fields[3], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: bad latitude: %s", path, line, err.Error())
+		}
+		lon, err := strconv.ParseFloat(fields[4], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: bad longitude: %s", path, line, err.Error())
+		}
+
+		st := street{
+			name:       fields[0],
+			city:       fields[1],
+			postalCode: fields[2],
+			key:        normalize(fields[0]),
+			cityKey:    normalize(fields[1]),
+			lat:        lat,
+			lon:        lon,
+		}
+		s.byCity[st.cityKey] = append(s.byCity[st.cityKey], len(s.streets))
+		s.streets = append(s.streets, st)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func normalize(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
+
+// similarity returns a score between 0 and 1 telling how alike a and b are,
+// based on their edit distance.
+func similarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	ra, rb := []rune(a), []rune(b)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// Search returns at most count locations whose street and city best match the
+// address, best first. The confidence of each match is set between 0 and 1.
+// If the city is known exactly, only its streets are considered.
+func (s *Streets) Search(address geotypes.Address, count int) []geotypes.Location {
+	streetKey, cityKey := normalize(address.Street), normalize(address.City)
+	if streetKey == "" && cityKey == "" {
+		return []geotypes.Location{}
+	}
+
+	type match struct {
+		index int
+		score float64
+	}
+
+	score := func(i int) float64 {
+		st := s.streets[i]
+		switch {
+		case cityKey == "":
+			return similarity(streetKey, st.key)
+		case streetKey == "":
+			return similarity(cityKey, st.cityKey)
+		}
+		return 0.7*similarity(streetKey, st.key) + 0.3*similarity(cityKey, st.cityKey)
+	}
+
+	var matches []match
+	if indices, ok := s.byCity[cityKey]; ok && cityKey != "" {
+		for _, i := range indices {
+			matches = append(matches, match{i, score(i)})
+		}
+	} else {
+		for i := range s.streets {
+			matches = append(matches, match{i, score(i)})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].score > matches[j].score
+	})
+	if len(matches) > count {
+		matches = matches[:count]
+	}
+
+	locations := make([]geotypes.Location, len(matches))
+	for i, m := range matches {
+		st := s.streets[m.index]
+		locations[i] = geotypes.Location{
+			Address: geotypes.Address{
+				Street:     st.name,
+				City:       st.city,
+				PostalCode: st.postalCode,
+				Country:    address.Country,
+				Confidence: m.score,
+			},
+			Coordinate: geotypes.Coordinate{
+				Latitude:  st.lat,
+				Longitude: st.lon,
+				System:    "WGS84",
+			},
+		}
+	}
+	return locations
+}
+
+// Len returns the number of streets in the index.
+func (s *Streets) Len() int {
+	return len(s.streets)
+}
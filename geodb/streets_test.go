@@ -0,0 +1,52 @@
+package geodb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nfleet/via/geotypes"
+)
+
+const testStreets = "Erottaja\tHelsinki\t00130\t60.1663\t24.9430\n" +
+	"Esplanadi\tHelsinki\t00130\t60.1675\t24.9483\n" +
+	"Vuolteenkatu\tTampere\t33100\t61.4994\t23.7737\n" +
+	"Taitoniekantie\tJyväskylä\t40740\t62.2426\t25.7128\n"
+
+func TestFuzzyAddress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geodb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "finland.streets"), []byte(testStreets), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db := NewDB(NewStore(dir), map[string]bool{"finland": true})
+
+	var tests = []struct {
+		in  geotypes.Address
+		out string
+	}{
+		{geotypes.Address{Street: "Esplanadi", City: "Helsinki", Country: "Finland"}, "Esplanadi"},
+		{geotypes.Address{Street: "esplanaadi", City: "helsinki", Country: "finland"}, "Esplanadi"},
+		{geotypes.Address{Street: "Vuolteenkatu", City: "Tampre", Country: "Finland"}, "Vuolteenkatu"},
+		{geotypes.Address{Street: "Taitoniekantie", Country: "Finland"}, "Taitoniekantie"},
+	}
+
+	for i, test := range tests {
+		res, err := db.QueryFuzzyAddress(test.in, 1)
+		if err != nil {
+			t.Fatalf("%d. QueryFuzzyAddress(%v) failed: %s", i, test.in, err.Error())
+		}
+		if len(res) != 1 || res[0].Address.Street != test.out {
+			t.Errorf("%d. QueryFuzzyAddress(%v) => %v, want %s", i, test.in, res, test.out)
+		}
+	}
+
+	if _, err := db.QueryFuzzyAddress(geotypes.Address{Street: "Unter den Linden", Country: "Germany"}, 1); err == nil {
+		t.Error("QueryFuzzyAddress for a country that is not served should fail")
+	}
+}
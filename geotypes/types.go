@@ -76,7 +76,7 @@ type CoordinatePath struct {
 	Distance int     `json:"distance"`
 	Time     int     `json:"time"`
 	Coords   []Coord `json:"coords"`
-	SameRoad bool    `json:"-""`
+	SameRoad bool    `json:"-"`
 }
 
 type Matrix struct {
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nfleet/via/ch"
+	viaErr "github.com/nfleet/via/error"
+)
+
+// Graph is a contraction hierarchies graph kept resident in memory.
+type Graph struct {
+	Country      string       `json:"country"`
+	SpeedProfile SpeedProfile `json:"speed_profile"`
+	Nodes        int          `json:"nodes"`
+	Edges        int          `json:"edges"`
+	LoadedAt     time.Time    `json:"loaded_at"`
+	LoadSeconds  float64      `json:"load_seconds"`
+	MemoryBytes  uint64       `json:"memory_bytes"`
+
+	handle ch.Graph
+}
+
+// InvalidNodes returns the indices of the IDs that are not nodes of the graph.
+func (g *Graph) InvalidNodes(ids []int) []int {
+	return invalidNodes(g.Nodes, ids)
+}
+
+func invalidNodes(nodes int, ids []int) []int {
+	var indices []int
+	for i, id := range ids {
+		if id < 0 || id >= nodes {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+type graphKey struct {
+	country      string
+	speedProfile SpeedProfile
+}
+
+type graphEntry struct {
+	ready chan struct{}
+	graph *Graph
+	err   error
+}
+
+// GraphRegistry loads every country/speed profile graph once and shares it
+// between requests. Queries never write to a loaded graph, so concurrent
+// computations can use the same graph without locking.
+type GraphRegistry struct {
+	dataDir string
+
+	mu      sync.Mutex
+	entries map[graphKey]*graphEntry
+	headers map[graphKey]graphHeader
+}
+
+// The counts read from the header of a graph file, see FileInfo. They hold as
+// long as the file keeps its size and modification time.
+type graphHeader struct {
+	bytes        int64
+	modified     time.Time
+	nodes, edges int
+}
+
+func NewGraphRegistry(dataDir string) *GraphRegistry {
+	return &GraphRegistry{
+		dataDir: dataDir,
+		entries: make(map[graphKey]*graphEntry),
+		headers: make(map[graphKey]graphHeader),
+	}
+}
+
+// GraphFile returns the path of the graph file for country and speed profile.
+func GraphFile(dataDir, country string, speedProfile SpeedProfile) string {
+	return filepath.Join(dataDir, fmt.Sprintf("%s-%s.sgr", country, speedProfile))
+}
+
+// Get returns the graph for country and speed profile, loading it on first use.
+// Concurrent callers asking for a graph that is being loaded wait for the load
+// to finish instead of reading the file again.
+func (r *GraphRegistry) Get(country string, speedProfile SpeedProfile) (*Graph, error) {
+	key := graphKey{country, speedProfile}
+
+	r.mu.Lock()
+	entry, ok := r.entries[key]
+	if ok {
+		r.mu.Unlock()
+		<-entry.ready
+		return entry.graph, entry.err
+	}
+	entry = &graphEntry{ready: make(chan struct{})}
+	r.entries[key] = entry
+	r.mu.Unlock()
+
+	entry.graph, entry.err = r.load(country, speedProfile)
+	if entry.err != nil {
+		// Forget failed loads so that a graph file added later gets picked up.
+		r.mu.Lock()
+		delete(r.entries, key)
+		r.mu.Unlock()
+	}
+	close(entry.ready)
+
+	return entry.graph, entry.err
+}
+
+// Preload loads the graphs of the given speed profiles in all their countries.
+// Graphs that fail to load are logged and retried on first use.
+func (r *GraphRegistry) Preload(profiles []Profile) {
+	for _, p := range profiles {
+		for _, country := range p.Countries {
+			if _, err := r.Get(country, p.Name); err != nil {
+				log.Printf("preloading %s-%s failed: %s", country, p.Name, err.Error())
+			}
+		}
+	}
+}
+
+// Loaded returns the graphs currently in memory, sorted by country and speed profile.
+func (r *GraphRegistry) Loaded() []Graph {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	graphs := []Graph{}
+	for _, entry := range r.entries {
+		select {
+		case <-entry.ready:
+			if entry.err == nil {
+				graphs = append(graphs, *entry.graph)
+			}
+		default:
+		}
+	}
+
+	sort.Slice(graphs, func(i, j int) bool {
+		if graphs[i].Country != graphs[j].Country {
+			return graphs[i].Country < graphs[j].Country
+		}
+		return graphs[i].SpeedProfile < graphs[j].SpeedProfile
+	})
+	return graphs
+}
+
+// GraphFileInfo describes a graph file of the data directory.
+type GraphFileInfo struct {
+	Country      string       `json:"country"`
+	SpeedProfile SpeedProfile `json:"speed_profile"`
+	Nodes        int          `json:"nodes"`
+	Edges        int          `json:"edges"`
+	Bytes        int64        `json:"bytes"`
+	Modified     time.Time    `json:"modified"`
+	Loaded       bool         `json:"loaded"`
+}
+
+// InvalidNodes returns the indices of the IDs that are not nodes of the graph,
+// without loading it.
+func (info GraphFileInfo) InvalidNodes(ids []int) []int {
+	return invalidNodes(info.Nodes, ids)
+}
+
+// Files describes the graph files of the profiles in all their countries,
+// sorted by country and speed profile. Files that can't be read are left out.
+func (r *GraphRegistry) Files(profiles []Profile) []GraphFileInfo {
+	files := []GraphFileInfo{}
+	for _, p := range profiles {
+		for _, country := range p.Countries {
+			info, err := r.FileInfo(country, p.Name)
+			if err != nil {
+				log.Printf("reading %s failed: %s", GraphFile(r.dataDir, country, p.Name), err.Error())
+				continue
+			}
+			files = append(files, info)
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].Country != files[j].Country {
+			return files[i].Country < files[j].Country
+		}
+		return files[i].SpeedProfile < files[j].SpeedProfile
+	})
+	return files
+}
+
+// FileInfo describes the graph file of country and speed profile. The size of
+// a graph that isn't loaded is read from the header of its file, once until
+// the file changes.
+func (r *GraphRegistry) FileInfo(country string, speedProfile SpeedProfile) (GraphFileInfo, error) {
+	path := GraphFile(r.dataDir, country, speedProfile)
+	stat, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return GraphFileInfo{}, &ch.Error{Kind: ch.GraphNotFound, Message: "File " + path + " could not be read."}
+	}
+	if err != nil {
+		return GraphFileInfo{}, err
+	}
+	info := GraphFileInfo{Country: country, SpeedProfile: speedProfile, Bytes: stat.Size(), Modified: stat.ModTime()}
+
+	if g := r.loaded(country, speedProfile); g != nil {
+		info.Nodes, info.Edges, info.Loaded = g.Nodes, g.Edges, true
+		return info, nil
+	}
+
+	key := graphKey{country, speedProfile}
+	r.mu.Lock()
+	header, ok := r.headers[key]
+	r.mu.Unlock()
+	if !ok || header.bytes != info.Bytes || !header.modified.Equal(info.Modified) {
+		header = graphHeader{bytes: info.Bytes, modified: info.Modified}
+		if header.nodes, header.edges, err = ch.ReadGraphInfo(path); err != nil {
+			return info, err
+		}
+		r.mu.Lock()
+		r.headers[key] = header
+		r.mu.Unlock()
+	}
+	info.Nodes, info.Edges = header.nodes, header.edges
+	return info, nil
+}
+
+// Returns the graph if it is loaded, without loading it.
+func (r *GraphRegistry) loaded(country string, speedProfile SpeedProfile) *Graph {
+	r.mu.Lock()
+	entry, ok := r.entries[graphKey{country, speedProfile}]
+	r.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	select {
+	case <-entry.ready:
+		return entry.graph
+	default:
+		return nil
+	}
+}
+
+// Close frees the loaded graphs. They must not be in use anymore.
+func (r *GraphRegistry) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key, entry := range r.entries {
+		select {
+		case <-entry.ready:
+			if entry.err == nil {
+				ch.DeleteGraph(entry.graph.handle)
+			}
+			delete(r.entries, key)
+		default:
+		}
+	}
+}
+
+func (r *GraphRegistry) load(country string, speedProfile SpeedProfile) (*Graph, error) {
+	path := GraphFile(r.dataDir, country, speedProfile)
+
+	t0 := time.Now()
+	handle, err := ch.LoadGraph(path)
+	if err != nil {
+		return nil, err
+	}
+	t1 := time.Since(t0)
+
+	log.Printf("loaded %s in %s", path, t1)
+
+	return &Graph{
+		Country:      country,
+		SpeedProfile: speedProfile,
+		Nodes:        int(handle.NoOfNodes()),
+		Edges:        int(handle.NoOfEdges()),
+		LoadedAt:     time.Now(),
+		LoadSeconds:  t1.Seconds(),
+		MemoryBytes:  uint64(handle.MemoryUsage()),
+		handle:       handle,
+	}, nil
+}
+
+// Turns an error of the CH library into an ErrContractionHierarchies error.
+// The server checks profiles and node IDs before calling the library, so its
+// failures are never the client's fault: a missing graph file makes the
+// graph unavailable (503), anything else is an internal error (500). Other
+// errors are reported with code.
+func chError(err error, code int) *viaErr.Error {
+	if err == errShuttingDown {
+		return viaErr.NewError(viaErr.ErrShuttingDown, err.Error())
+	}
+
+	chErr, ok := err.(*ch.Error)
+	if !ok {
+		return viaErr.NewError(code, err.Error())
+	}
+
+	e := viaErr.NewError(viaErr.ErrContractionHierarchies, chErr.Error())
+	if chErr.Kind == ch.GraphNotFound {
+		e.Status = http.StatusServiceUnavailable
+	}
+	return e
+}
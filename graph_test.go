@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nfleet/via/ch"
+	viaErr "github.com/nfleet/via/error"
+	"github.com/nfleet/via/geotypes"
+)
+
+func TestInvalidNodes(t *testing.T) {
+	g := &Graph{Country: "finland", SpeedProfile: "100", Nodes: 10}
+
+	got := g.InvalidNodes([]int{0, 9, 10, -1, 5, 4294967295})
+	if want := []int{2, 3, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("InvalidNodes => %v, want %v", got, want)
+	}
+	if got := g.InvalidNodes([]int{1, 2, 3}); got != nil {
+		t.Errorf("InvalidNodes of valid nodes => %v, want none", got)
+	}
+}
+
+func TestFileInfoOfMissingGraph(t *testing.T) {
+	r := NewGraphRegistry(os.TempDir())
+	_, err := r.FileInfo("nowhere", "100")
+	if e := chError(err, viaErr.ErrContractionHierarchies); e.Status != 503 {
+		t.Errorf("missing graph file => %v, status %d, want 503", err, e.Status)
+	}
+}
+
+func TestCHError(t *testing.T) {
+	tests := []struct {
+		err    error
+		code   int
+		status int
+	}{
+		{&ch.Error{Kind: ch.GraphNotFound, Message: "no file"}, viaErr.ErrContractionHierarchies, 503},
+		{&ch.Error{Kind: ch.ParseFailure, Message: "bad json"}, viaErr.ErrContractionHierarchies, 0},
+		{&ch.Error{Kind: ch.Internal, Message: "bad_alloc"}, viaErr.ErrContractionHierarchies, 0},
+		{errors.New("other"), viaErr.ErrMatrixComputation, 0},
+	}
+
+	for _, tt := range tests {
+		e := chError(tt.err, viaErr.ErrMatrixComputation)
+		if e.ErrorCode != tt.code || e.Status != tt.status {
+			t.Errorf("chError(%v) => code %d status %d, want %d and %d", tt.err, e.ErrorCode, e.Status, tt.code, tt.status)
+		}
+	}
+}
+
+type fakeGeoDB struct {
+	geotypes.GeoDB
+	err error
+}
+
+func (db fakeGeoDB) QueryStatus() error { return db.err }
+
+// Writes graph files with just the header that ch.ReadGraphInfo reads: the
+// node count with a dummy node, the 8-byte search nodes and the edge count.
+func writeGraphHeaders(t *testing.T, dir string, names ...string) {
+	const nodes = 10
+	data := make([]byte, 4+8*(nodes+1)+4)
+	binary.LittleEndian.PutUint32(data, nodes+1)
+	binary.LittleEndian.PutUint32(data[len(data)-4:], 2*nodes)
+	for _, name := range names {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	dir, err := ioutil.TempDir("", "via-ready")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	countries := map[string]bool{"finland": true, "germany": true}
+	server := Server{
+		Via:              NewVia(false, false, 60, dir),
+		Geo:              fakeGeoDB{},
+		AllowedCountries: countries,
+		Profiles:         NewProfileRegistry(dir, countries, nil),
+	}
+
+	status := server.Readiness()
+	if status.Status != StatusUnavailable || len(status.Errors) != 1 || status.Errors[0] != "no speed profiles" {
+		t.Errorf("empty data directory => %+v", status)
+	}
+
+	// The graphs found at start-up are required.
+	writeGraphHeaders(t, dir, "finland-100.sgr")
+	server.Profiles = NewProfileRegistry(dir, countries, nil)
+	server.Geo = fakeGeoDB{err: errors.New("connection refused")}
+	status = server.Readiness()
+	if status.Status != StatusUnavailable || status.GeoDB != "connection refused" {
+		t.Errorf("missing graph and geodb => %+v", status)
+	}
+	if !containsString(status.Errors, "no graph for germany") {
+		t.Errorf("germany without graphs not reported in %v", status.Errors)
+	}
+	for _, e := range status.Errors {
+		if strings.Contains(e, "connection refused") {
+			t.Errorf("geodb failure keeps the server from being ready: %v", status.Errors)
+		}
+	}
+
+	// A profile only some countries have doesn't need graphs for the others.
+	writeGraphHeaders(t, dir, "germany-100.sgr", "finland-70.sgr")
+	server.Profiles = NewProfileRegistry(dir, countries, nil)
+	status = server.Readiness()
+	if status.Status != StatusOK {
+		t.Errorf("complete countries => %+v", status)
+	}
+
+	// A graph removed after start-up stays missing after a scan.
+	os.Remove(filepath.Join(dir, "germany-100.sgr"))
+	server.Profiles.Scan()
+	status = server.Readiness()
+	missing := false
+	for _, e := range status.Errors {
+		missing = missing || strings.HasPrefix(e, "graph germany-100: ")
+	}
+	if status.Status != StatusUnavailable || !missing {
+		t.Errorf("removed germany-100 not reported in %+v", status)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	viaErr "github.com/nfleet/via/error"
+)
+
+// Matrix job progress values.
+const (
+	JobQueued   = "queued"
+	JobRunning  = "running"
+	JobComplete = "complete"
+	JobFailed   = "failed"
+)
+
+// MatrixJob is an asynchronous matrix computation.
+type MatrixJob struct {
+	ID       string        `json:"id"`
+	Progress string        `json:"progress"`
+	Result   *Result       `json:"-"`
+	Err      *viaErr.Error `json:"-"`
+	Expires  time.Time     `json:"-"`
+}
+
+// JobStore keeps track of asynchronous matrix jobs. Finished jobs are kept
+// for the expiry duration and then forgotten.
+type JobStore struct {
+	expiry time.Duration
+
+	mu   sync.Mutex
+	jobs map[string]*MatrixJob
+}
+
+func NewJobStore(expiry time.Duration) *JobStore {
+	return &JobStore{
+		expiry: expiry,
+		jobs:   make(map[string]*MatrixJob),
+	}
+}
+
+func newJobID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic("reading random job id failed: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Create registers a new queued job.
+func (s *JobStore) Create() MatrixJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.purge(time.Now())
+
+	job := &MatrixJob{ID: newJobID(), Progress: JobQueued}
+	s.jobs[job.ID] = job
+	return *job
+}
+
+// Get returns a copy of the job with the given id.
+func (s *JobStore) Get(id string) (MatrixJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.purge(time.Now())
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return MatrixJob{}, false
+	}
+	return *job, true
+}
+
+// Start marks the job as running.
+func (s *JobStore) Start(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if job, ok := s.jobs[id]; ok {
+		job.Progress = JobRunning
+	}
+}
+
+// Finish stores the outcome of the job and starts its expiry clock.
+func (s *JobStore) Finish(id string, result *Result, err *viaErr.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return
+	}
+
+	if err != nil {
+		job.Progress = JobFailed
+	} else {
+		job.Progress = JobComplete
+	}
+	job.Result = result
+	job.Err = err
+	job.Expires = time.Now().Add(s.expiry)
+}
+
+// purge drops finished jobs that have expired. Must be called with s.mu held.
+func (s *JobStore) purge(now time.Time) {
+	for id, job := range s.jobs {
+		if !job.Expires.IsZero() && now.After(job.Expires) {
+			delete(s.jobs, id)
+		}
+	}
+}
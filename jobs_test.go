@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	viaErr "github.com/nfleet/via/error"
+)
+
+func TestJobLifecycle(t *testing.T) {
+	store := NewJobStore(time.Hour)
+
+	job := store.Create()
+	if job.Progress != JobQueued {
+		t.Fatalf("new job has progress %q, want %q", job.Progress, JobQueued)
+	}
+
+	store.Start(job.ID)
+	if got, _ := store.Get(job.ID); got.Progress != JobRunning {
+		t.Errorf("started job has progress %q, want %q", got.Progress, JobRunning)
+	}
+
+	store.Finish(job.ID, &Result{Progress: JobComplete}, nil)
+	got, ok := store.Get(job.ID)
+	if !ok || got.Progress != JobComplete || got.Result == nil {
+		t.Errorf("finished job => %+v, %v, want complete with result", got, ok)
+	}
+}
+
+func TestJobFailure(t *testing.T) {
+	store := NewJobStore(time.Hour)
+
+	job := store.Create()
+	store.Finish(job.ID, nil, viaErr.NewError(viaErr.ErrMatrixComputation, "boom"))
+
+	got, _ := store.Get(job.ID)
+	if got.Progress != JobFailed || got.Err == nil {
+		t.Errorf("failed job => %+v, want failed with error", got)
+	}
+}
+
+func TestJobExpiry(t *testing.T) {
+	store := NewJobStore(time.Millisecond)
+
+	job := store.Create()
+	store.Finish(job.ID, &Result{}, nil)
+	time.Sleep(5 * time.Millisecond)
+
+	if _, ok := store.Get(job.ID); ok {
+		t.Errorf("job %s should have expired", job.ID)
+	}
+}
+
+func TestUnfinishedJobsDoNotExpire(t *testing.T) {
+	store := NewJobStore(time.Millisecond)
+
+	job := store.Create()
+	time.Sleep(5 * time.Millisecond)
+
+	if _, ok := store.Get(job.ID); !ok {
+		t.Errorf("queued job %s should not expire", job.ID)
+	}
+}
@@ -1,39 +1,133 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
 	"runtime"
-	"strings"
+	"strconv"
 	"time"
 
 	"github.com/nfleet/via/ch"
+	viaErr "github.com/nfleet/via/error"
 )
 
-// Computes a matrix hash. This should be launched in a goroutine, not in the main thread.
-func (v *Via) ComputeMatrix(nodes []int, country string, speedProfile int) (map[string][]int, error) {
+// Queues a matrix computation and returns at once. The progress and the result
+// of the returned job can be looked up from v.Jobs.
+func (v *Via) SubmitMatrix(compute func() (*Result, *viaErr.Error)) MatrixJob {
+	job := v.Jobs.Create()
+
+	go func() {
+		v.jobSlots <- struct{}{}
+		defer func() { <-v.jobSlots }()
+
+		v.Jobs.Start(job.ID)
+		result, err := compute()
+		v.Jobs.Finish(job.ID, result, err)
+	}()
+
+	return job
+}
+
+// Matrix is a matrix of graph weights stored row by row.
+type Matrix struct {
+	Rows, Cols int
+	Weights    []uint32
+}
+
+// Row returns the weights of row i.
+func (m Matrix) Row(i int) []uint32 {
+	return m.Weights[i*m.Cols : (i+1)*m.Cols]
+}
+
+// Keyed returns the matrix as a map from the row number to the row.
+func (m Matrix) Keyed() map[string][]int {
+	keyed := make(map[string][]int, m.Rows)
+	for i := 0; i < m.Rows; i++ {
+		row := make([]int, m.Cols)
+		for j, w := range m.Row(i) {
+			row[j] = int(w)
+		}
+		keyed[strconv.Itoa(i)] = row
+	}
+	return keyed
+}
+
+// Dense returns the matrix as a list of rows in source order.
+func (m Matrix) Dense() [][]int {
+	dense := make([][]int, m.Rows)
+	for i := range dense {
+		dense[i] = make([]int, m.Cols)
+		for j, w := range m.Row(i) {
+			dense[i][j] = int(w)
+		}
+	}
+	return dense
+}
+
+// Matrix formats of matrix results.
+const (
+	FormatKeyed = "keyed" // an object from the row number to the row
+	FormatDense = "dense" // a list of rows
+)
+
+// Formatted returns the matrix in format, or false if the format is unknown.
+func (m Matrix) Formatted(format string) (interface{}, bool) {
+	switch format {
+	case FormatKeyed:
+		return m.Keyed(), true
+	case FormatDense:
+		return m.Dense(), true
+	}
+	return nil, false
+}
+
+func toNodeIDs(ids []int) []uint32 {
+	nodeIDs := make([]uint32, len(ids))
+	for i, id := range ids {
+		nodeIDs[i] = uint32(id)
+	}
+	return nodeIDs
+}
+
+// Computes a matrix. This should be launched in a goroutine, not in the main thread.
+// Row i holds the weights from sources[i] to every node in targets. If targets is
+// empty, the matrix is computed from sources to sources. The node IDs must be
+// nodes of the graph, see Graph.InvalidNodes.
+// With parallel set, the rows are split across the cores no other computation
+// uses; the result is the same.
+func (v *Via) ComputeMatrix(sources, targets []int, country string, speedProfile SpeedProfile, parallel bool) (Matrix, error) {
 	var memStats runtime.MemStats
 	runtime.ReadMemStats(&memStats)
-	v.Debug.Printf("entering ComputeMatrix for hash, memory used: %d mb.", memStats.Alloc/1e6)
-	empty := map[string][]int{}
+	v.Debug.Printf("entering ComputeMatrix, memory used: %d mb.", memStats.Alloc/1e6)
 	t0 := time.Now()
 
-	matrixData := struct {
-		Sources []int `json:"sources"`
-	}{nodes}
-	jsonData, err := json.Marshal(matrixData)
-	if err != nil {
-		panic("nodes to json error:" + err.Error())
+	key := matrixKey("matrix", country, speedProfile, sources, targets)
+	if m, ok := v.cachedMatrix(key); ok {
+		v.Debug.Println("found matrix in cache")
+		return m, nil
+	}
+
+	if err := v.enter(); err != nil {
+		return Matrix{}, err
 	}
+	defer v.leave()
 
 	v.Debug.Println("got country", string(country), "with profile", speedProfile)
 
-	res := ch.Calc_dm(string(jsonData), string(country), int(speedProfile), v.DataDir)
+	graph, err := v.Graphs.Get(country, speedProfile)
+	if err != nil {
+		return Matrix{}, err
+	}
 
-	var matrix map[string][]int
-	if err := json.NewDecoder(strings.NewReader(res)).Decode(&matrix); err != nil {
-		v.Debug.Println("failed to parse CH results")
-		return empty, fmt.Errorf("Failed to parse CH results: %s", err.Error())
+	threads := v.takeThreads(parallel)
+	defer v.releaseThreads(threads)
+	v.Debug.Println("computing on", threads, "threads")
+	weights, err := ch.Matrix(graph.handle, toNodeIDs(sources), toNodeIDs(targets), threads)
+	if err != nil {
+		return Matrix{}, err
+	}
+
+	cols := len(targets)
+	if cols == 0 {
+		cols = len(sources)
 	}
 
 	t1 := time.Since(t0)
@@ -43,5 +137,256 @@ func (v *Via) ComputeMatrix(nodes []int, country string, speedProfile int) (map[
 	runtime.ReadMemStats(&memStats)
 	v.Debug.Printf("Computation completed with memory usage still at %d mb.\n", memStats.Alloc/1e6)
 
-	return matrix, nil
+	m := Matrix{Rows: len(sources), Cols: cols, Weights: weights}
+	v.cacheMatrix(key, m)
+	return m, nil
+}
+
+// Returns the cache key of a matrix from sources to targets, or to sources if
+// targets is empty.
+func matrixKey(kind, country string, speedProfile SpeedProfile, sources, targets []int) string {
+	if len(targets) == 0 {
+		targets = sources
+	}
+	return cacheKey(kind, country, speedProfile, sources, targets)
+}
+
+func (v *Via) cachedMatrix(key string) (Matrix, bool) {
+	var m Matrix
+	if v.Cache == nil {
+		return m, false
+	}
+	data, ok := v.Cache.Get(key)
+	if !ok {
+		return m, false
+	}
+	if err := m.UnmarshalBinary(data); err != nil {
+		v.Debug.Println("dropping cached matrix:", err)
+		return m, false
+	}
+	return m, true
+}
+
+// Tells whether a result is cached under key.
+func (v *Via) isCached(key string) bool {
+	if v.Cache == nil {
+		return false
+	}
+	_, ok := v.Cache.Get(key)
+	return ok
+}
+
+func (v *Via) cacheMatrix(key string, m Matrix) {
+	if v.Cache == nil {
+		return
+	}
+	data, _ := m.MarshalBinary()
+	v.Cache.Set(key, data, time.Duration(v.Expiry)*time.Second)
+}
+
+// Builds the matrix from sources to targets out of m, which it extends. The
+// first len(keptRows) sources are the sources of those rows of m and the first
+// len(keptCols) targets the targets of those columns; the rest are new. Only
+// the new rows, and the new columns of the kept rows, are passed to compute.
+func extendMatrix(m Matrix, keptRows, keptCols, sources, targets []int, compute func(sources, targets []int) (Matrix, error)) (Matrix, error) {
+	ext := Matrix{Rows: len(sources), Cols: len(targets), Weights: make([]uint32, len(sources)*len(targets))}
+
+	for i, row := range keptRows {
+		old := m.Row(row)
+		for j, col := range keptCols {
+			ext.Weights[i*ext.Cols+j] = old[col]
+		}
+	}
+
+	oldSources, newSources := sources[:len(keptRows)], sources[len(keptRows):]
+	newTargets := targets[len(keptCols):]
+	if len(oldSources) > 0 && len(newTargets) > 0 {
+		cols, err := compute(oldSources, newTargets)
+		if err != nil {
+			return Matrix{}, err
+		}
+		for i := range oldSources {
+			copy(ext.Row(i)[len(keptCols):], cols.Row(i))
+		}
+	}
+	if len(newSources) > 0 && len(targets) > 0 {
+		rows, err := compute(newSources, targets)
+		if err != nil {
+			return Matrix{}, err
+		}
+		copy(ext.Weights[len(oldSources)*ext.Cols:], rows.Weights)
+	}
+	return ext, nil
+}
+
+// Computes the matrix from sources to targets like ComputeMatrix, reusing the
+// kept rows and columns of m, the matrix of an earlier request; see
+// extendMatrix. The result is cached like a matrix computed from scratch.
+func (v *Via) ExtendMatrix(m Matrix, keptRows, keptCols, sources, targets []int, country string, speedProfile SpeedProfile, parallel bool) (Matrix, error) {
+	key := matrixKey("matrix", country, speedProfile, sources, targets)
+	if cached, ok := v.cachedMatrix(key); ok {
+		return cached, nil
+	}
+
+	ext, err := extendMatrix(m, keptRows, keptCols, sources, targets, func(sources, targets []int) (Matrix, error) {
+		return v.ComputeMatrix(sources, targets, country, speedProfile, parallel)
+	})
+	if err != nil {
+		return Matrix{}, err
+	}
+	v.cacheMatrix(key, ext)
+	return ext, nil
+}
+
+// Computes the distances like ComputeDistances, reusing those of m like
+// ExtendMatrix.
+func (v *Via) ExtendDistances(m Matrix, keptRows, keptCols, sources, targets []int, country string, speedProfile SpeedProfile, parallel bool) (Matrix, error) {
+	key := matrixKey("distances", country, speedProfile, sources, targets)
+	if cached, ok := v.cachedMatrix(key); ok {
+		return cached, nil
+	}
+
+	ext, err := extendMatrix(m, keptRows, keptCols, sources, targets, func(sources, targets []int) (Matrix, error) {
+		return v.ComputeDistances(sources, targets, country, speedProfile, parallel)
+	})
+	if err != nil {
+		return Matrix{}, err
+	}
+	v.cacheMatrix(key, ext)
+	return ext, nil
+}
+
+// Computes the matrix like ComputeMatrix, but one row at a time, and calls row
+// with every row as soon as it is done, so only one row is held in memory.
+// With withDistances set, row also gets the distances of the row like
+// ComputeDistances, and nil otherwise. The slices passed to row are reused
+// for the next row. Computing stops at the first error returned by row.
+// A cached matrix is streamed from the cache, but streamed matrices are not
+// cached, as that would hold all of them in memory.
+func (v *Via) StreamMatrix(sources, targets []int, country string, speedProfile SpeedProfile, withDistances bool, row func(i int, weights, distances []uint32) error) error {
+	if len(targets) == 0 {
+		targets = sources
+	}
+
+	m, ok := v.cachedMatrix(matrixKey("matrix", country, speedProfile, sources, targets))
+	var d Matrix
+	if ok && withDistances {
+		d, ok = v.cachedMatrix(matrixKey("distances", country, speedProfile, sources, targets))
+	}
+	if ok {
+		for i := 0; i < m.Rows; i++ {
+			var distances []uint32
+			if withDistances {
+				distances = d.Row(i)
+			}
+			if err := row(i, m.Row(i), distances); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := v.enter(); err != nil {
+		return err
+	}
+	defer v.leave()
+
+	graph, err := v.Graphs.Get(country, speedProfile)
+	if err != nil {
+		return err
+	}
+	var lats, lons []float32
+	if withDistances {
+		nodes, err := v.Nodes.Nodes(country)
+		if err != nil {
+			return err
+		}
+		lats, lons = nodes.Positions()
+	}
+
+	defer v.releaseThreads(v.takeThreads(false))
+
+	targetIDs := toNodeIDs(targets)
+	rows, err := ch.NewRows(graph.handle, targetIDs)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	weights := make([]uint32, len(targets))
+	for i, source := range sources {
+		if err := rows.Row(uint32(source), weights); err != nil {
+			return err
+		}
+		var distances []uint32
+		if withDistances {
+			if distances, err = ch.Distances(graph.handle, []uint32{uint32(source)}, targetIDs, lats, lons, 1); err != nil {
+				return err
+			}
+		}
+		if err := row(i, weights, distances); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Converts a matrix of weights to travel times in seconds. Pairs without a
+// route keep the unreachable weight.
+func (v *Via) Durations(m Matrix) Matrix {
+	durations := Matrix{Rows: m.Rows, Cols: m.Cols, Weights: make([]uint32, len(m.Weights))}
+	for i, w := range m.Weights {
+		if w == unreachable {
+			durations.Weights[i] = w
+			continue
+		}
+		durations.Weights[i] = uint32(v.Seconds(int(w)))
+	}
+	return durations
+}
+
+// Computes the length in meters of the shortest path between every source
+// and target, following the path geometry like PathCoordinates.
+// Every pair needs a query of its own, so this is much slower than ComputeMatrix.
+// Pairs without a route get the unreachable weight.
+func (v *Via) ComputeDistances(sources, targets []int, country string, speedProfile SpeedProfile, parallel bool) (Matrix, error) {
+	t0 := time.Now()
+
+	key := matrixKey("distances", country, speedProfile, sources, targets)
+	if m, ok := v.cachedMatrix(key); ok {
+		return m, nil
+	}
+
+	if err := v.enter(); err != nil {
+		return Matrix{}, err
+	}
+	defer v.leave()
+
+	graph, err := v.Graphs.Get(country, speedProfile)
+	if err != nil {
+		return Matrix{}, err
+	}
+	nodes, err := v.Nodes.Nodes(country)
+	if err != nil {
+		return Matrix{}, err
+	}
+	lats, lons := nodes.Positions()
+
+	threads := v.takeThreads(parallel)
+	defer v.releaseThreads(threads)
+	distances, err := ch.Distances(graph.handle, toNodeIDs(sources), toNodeIDs(targets), lats, lons, threads)
+	if err != nil {
+		return Matrix{}, err
+	}
+
+	cols := len(targets)
+	if cols == 0 {
+		cols = len(sources)
+	}
+
+	v.Debug.Println("calculated distances in", time.Since(t0))
+
+	m := Matrix{Rows: len(sources), Cols: cols, Weights: distances}
+	v.cacheMatrix(key, m)
+	return m, nil
 }
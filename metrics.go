@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	latencyBuckets   = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+	dimensionBuckets = []float64{1, 10, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+)
+
+type histogram struct {
+	buckets []float64
+	counts  []uint64 // per bucket, the last one is +Inf
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets)+1)}
+}
+
+func (h *histogram) observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+// Label values of a request. The handlers fill in the country and speed
+// profile once they know them, see labelRequest.
+type requestInfo struct {
+	country, speedProfile string
+}
+
+type requestInfoKey struct{}
+
+// labelRequest sets the country and speed profile labels of the metrics of r.
+func labelRequest(r *http.Request, country string, speedProfile SpeedProfile) {
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		info.country, info.speedProfile = country, string(speedProfile)
+	}
+}
+
+// Metrics collects the request and matrix metrics served on /metrics in the
+// Prometheus text format. Graph and computation metrics are read from Via
+// when scraped.
+type Metrics struct {
+	mu        sync.Mutex
+	requests  map[[4]string]uint64     // endpoint, country, speed profile, status code
+	latencies map[[3]string]*histogram // endpoint, country, speed profile
+	sources   map[[2]string]*histogram // country, speed profile
+	targets   map[[2]string]*histogram
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests:  make(map[[4]string]uint64),
+		latencies: make(map[[3]string]*histogram),
+		sources:   make(map[[2]string]*histogram),
+		targets:   make(map[[2]string]*histogram),
+	}
+}
+
+// statusWriter remembers the status code written to it.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Flush lets streamed matrices through.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Instrument counts and times the requests served by h.
+func (m *Metrics) Instrument(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := &requestInfo{}
+		r = r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info))
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+
+		t0 := time.Now()
+		h.ServeHTTP(sw, r)
+		elapsed := time.Since(t0).Seconds()
+
+		endpoint := endpointOf(r)
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		m.requests[[4]string{endpoint, info.country, info.speedProfile, strconv.Itoa(sw.status)}]++
+		key := [3]string{endpoint, info.country, info.speedProfile}
+		latency, ok := m.latencies[key]
+		if !ok {
+			latency = newHistogram(latencyBuckets)
+			m.latencies[key] = latency
+		}
+		latency.observe(elapsed)
+	})
+}
+
+// ObserveMatrix records the dimensions of a requested matrix.
+func (m *Metrics) ObserveMatrix(country string, speedProfile SpeedProfile, rows, cols int) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := [2]string{country, string(speedProfile)}
+	for _, obs := range []struct {
+		hists map[[2]string]*histogram
+		v     int
+	}{{m.sources, rows}, {m.targets, cols}} {
+		h, ok := obs.hists[key]
+		if !ok {
+			h = newHistogram(dimensionBuckets)
+			obs.hists[key] = h
+		}
+		h.observe(float64(obs.v))
+	}
+}
+
+// Names the endpoint of a request for the metrics, without the IDs in the path.
+func endpointOf(r *http.Request) string {
+	p := r.URL.Path
+	switch {
+	case r.Method == "OPTIONS":
+		return "options"
+	case p == "/":
+		return "splash"
+	case p == "/matrix/" || p == "/matrix":
+		return "matrix"
+	case strings.HasPrefix(p, "/matrix/") && strings.HasSuffix(p, "/result"):
+		return "matrix_result"
+	case strings.HasPrefix(p, "/matrix/") && strings.HasSuffix(p, "/update"):
+		return "matrix_update"
+	case strings.HasPrefix(p, "/matrix/"):
+		return "matrix_job"
+	case p == "/paths", p == "/resolve", p == "/status", p == "/metrics", p == "/profiles":
+		return p[1:]
+	case p == "/health/live", p == "/health/ready":
+		return strings.Replace(p[1:], "/", "_", 1)
+	}
+	return "other"
+}
+
+// WriteTo writes the metrics and those of via in the Prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer, via *Via) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP via_http_requests_total Requests served, by endpoint, country, speed profile and status code.")
+	fmt.Fprintln(w, "# TYPE via_http_requests_total counter")
+	requestKeys := make([][4]string, 0, len(m.requests))
+	for k := range m.requests {
+		requestKeys = append(requestKeys, k)
+	}
+	sort.Slice(requestKeys, func(i, j int) bool { return lessLabels(requestKeys[i][:], requestKeys[j][:]) })
+	for _, k := range requestKeys {
+		fmt.Fprintf(w, "via_http_requests_total%s %d\n", labels("endpoint", k[0], "country", k[1], "speed_profile", k[2], "code", k[3]), m.requests[k])
+	}
+
+	fmt.Fprintln(w, "# HELP via_http_request_duration_seconds Request latency, by endpoint, country and speed profile.")
+	fmt.Fprintln(w, "# TYPE via_http_request_duration_seconds histogram")
+	latencyKeys := make([][3]string, 0, len(m.latencies))
+	for k := range m.latencies {
+		latencyKeys = append(latencyKeys, k)
+	}
+	sort.Slice(latencyKeys, func(i, j int) bool { return lessLabels(latencyKeys[i][:], latencyKeys[j][:]) })
+	for _, k := range latencyKeys {
+		writeHistogram(w, "via_http_request_duration_seconds", m.latencies[k], "endpoint", k[0], "country", k[1], "speed_profile", k[2])
+	}
+
+	for _, dim := range []struct {
+		name, help string
+		hists      map[[2]string]*histogram
+	}{
+		{"via_matrix_sources", "Sources of the requested matrices.", m.sources},
+		{"via_matrix_targets", "Targets of the requested matrices.", m.targets},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n", dim.name, dim.help)
+		fmt.Fprintf(w, "# TYPE %s histogram\n", dim.name)
+		keys := make([][2]string, 0, len(dim.hists))
+		for k := range dim.hists {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return lessLabels(keys[i][:], keys[j][:]) })
+		for _, k := range keys {
+			writeHistogram(w, dim.name, dim.hists[k], "country", k[0], "speed_profile", k[1])
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP via_computations_in_flight Matrix and path computations running.")
+	fmt.Fprintln(w, "# TYPE via_computations_in_flight gauge")
+	fmt.Fprintf(w, "via_computations_in_flight %d\n", via.Running())
+
+	graphs := via.Graphs.Loaded()
+	fmt.Fprintln(w, "# HELP via_graph_load_seconds Time it took to load a graph.")
+	fmt.Fprintln(w, "# TYPE via_graph_load_seconds gauge")
+	for _, g := range graphs {
+		fmt.Fprintf(w, "via_graph_load_seconds%s %g\n", labels("country", g.Country, "speed_profile", string(g.SpeedProfile)), g.LoadSeconds)
+	}
+	fmt.Fprintln(w, "# HELP via_graph_memory_bytes Memory used by a loaded graph.")
+	fmt.Fprintln(w, "# TYPE via_graph_memory_bytes gauge")
+	for _, g := range graphs {
+		fmt.Fprintf(w, "via_graph_memory_bytes%s %d\n", labels("country", g.Country, "speed_profile", string(g.SpeedProfile)), g.MemoryBytes)
+	}
+}
+
+func writeHistogram(w io.Writer, name string, h *histogram, labelPairs ...string) {
+	var cumulative uint64
+	for i, bound := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, labels(append(labelPairs, "le", strconv.FormatFloat(bound, 'g', -1, 64))...), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket%s %d\n", name, labels(append(labelPairs, "le", "+Inf")...), h.count)
+	fmt.Fprintf(w, "%s_sum%s %g\n", name, labels(labelPairs...), h.sum)
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels(labelPairs...), h.count)
+}
+
+// Formats name/value pairs as a Prometheus label set.
+func labels(pairs ...string) string {
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, pairs[i]+"="+strconv.Quote(pairs[i+1]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func lessLabels(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics()
+	handler := m.Instrument(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/paths" {
+			labelRequest(r, "finland", "2")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		}
+	}))
+
+	for _, path := range []string{"/paths", "/paths", "/matrix/abc/result"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", path, nil))
+	}
+	m.ObserveMatrix("finland", "2", 30, 300)
+
+	v := NewVia(false, false, 60, "")
+	if err := v.enter(); err != nil {
+		t.Fatal(err)
+	}
+	defer v.leave()
+
+	var buf bytes.Buffer
+	m.WriteTo(&buf, v)
+	out := buf.String()
+
+	for _, line := range []string{
+		`via_http_requests_total{endpoint="paths",country="finland",speed_profile="2",code="422"} 2`,
+		`via_http_requests_total{endpoint="matrix_result",country="",speed_profile="",code="200"} 1`,
+		`via_http_request_duration_seconds_count{endpoint="paths",country="finland",speed_profile="2"} 2`,
+		`via_matrix_sources_bucket{country="finland",speed_profile="2",le="10"} 0`,
+		`via_matrix_sources_bucket{country="finland",speed_profile="2",le="50"} 1`,
+		`via_matrix_targets_bucket{country="finland",speed_profile="2",le="250"} 0`,
+		`via_matrix_targets_bucket{country="finland",speed_profile="2",le="+Inf"} 1`,
+		`via_matrix_targets_sum{country="finland",speed_profile="2"} 300`,
+		`via_computations_in_flight 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("metrics lack %s, got\n%s", line, out)
+		}
+	}
+}
@@ -2,13 +2,25 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"strings"
+	"time"
 
 	"github.com/nfleet/via/ch"
+	"github.com/nfleet/via/geodb"
 	"github.com/nfleet/via/geotypes"
 )
 
-func (v *Via) CalculatePaths(nodeEdges []geotypes.NodeEdge, country string, speed_profile int) ([]geotypes.Path, error) {
+// Returns the cache key of the paths between the pairs of nodeEdges.
+func pathsKey(nodeEdges []geotypes.NodeEdge, country string, speedProfile SpeedProfile) string {
+	sources, targets := make([]int, len(nodeEdges)), make([]int, len(nodeEdges))
+	for i, pair := range nodeEdges {
+		sources[i], targets[i] = pair.Source, pair.Target
+	}
+	return cacheKey("paths", country, speedProfile, sources, targets)
+}
+
+func (v *Via) CalculatePaths(nodeEdges []geotypes.NodeEdge, country string, speed_profile SpeedProfile) ([]geotypes.Path, error) {
 	input_data, err := json.Marshal(nodeEdges)
 	if err != nil {
 		return []geotypes.Path{}, err
@@ -16,7 +28,30 @@ func (v *Via) CalculatePaths(nodeEdges []geotypes.NodeEdge, country string, spee
 
 	country = strings.ToLower(country)
 
-	res := ch.Calc_paths(string(input_data), country, speed_profile, v.DataDir)
+	key := pathsKey(nodeEdges, country, speed_profile)
+	if v.Cache != nil {
+		if cached, ok := v.Cache.Get(key); ok {
+			var paths []geotypes.Path
+			if err := json.Unmarshal(cached, &paths); err == nil {
+				return paths, nil
+			}
+		}
+	}
+
+	if err := v.enter(); err != nil {
+		return []geotypes.Path{}, err
+	}
+	defer v.leave()
+
+	graph, err := v.Graphs.Get(country, speed_profile)
+	if err != nil {
+		return []geotypes.Path{}, err
+	}
+
+	res, err := ch.CalcPaths(graph.handle, string(input_data))
+	if err != nil {
+		return []geotypes.Path{}, err
+	}
 	var edges struct {
 		Edges []geotypes.Path `json:"edges"`
 	}
@@ -25,5 +60,65 @@ func (v *Via) CalculatePaths(nodeEdges []geotypes.NodeEdge, country string, spee
 		return []geotypes.Path{}, err
 	}
 
+	if v.Cache != nil {
+		if encoded, err := json.Marshal(edges.Edges); err == nil {
+			v.Cache.Set(key, encoded, time.Duration(v.Expiry)*time.Second)
+		}
+	}
+
 	return edges.Edges, nil
 }
+
+// Length of a path whose target can't be reached from its source.
+const unreachable = 4294967295
+
+// Returns the indices of the paths that found no route.
+func unreachablePaths(paths []geotypes.Path) []int {
+	var indices []int
+	for i, path := range paths {
+		if path.Length == unreachable {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+// Turns every path computed by CalculatePaths into the coordinates of its
+// nodes. Distance is the length of the path geometry in meters and Time is
+// the path weight converted to seconds; both are 4294967295 for paths that
+// found no route.
+func (v *Via) PathCoordinates(paths []geotypes.Path, country string) ([]geotypes.CoordinatePath, error) {
+	nodes, err := v.Nodes.Nodes(strings.ToLower(country))
+	if err != nil {
+		return []geotypes.CoordinatePath{}, err
+	}
+
+	coordPaths := make([]geotypes.CoordinatePath, len(paths))
+	for i, path := range paths {
+		if path.Length == unreachable {
+			coordPaths[i] = geotypes.CoordinatePath{Distance: unreachable, Time: unreachable, Coords: []geotypes.Coord{}}
+			continue
+		}
+
+		coords := make([]geotypes.Coord, len(path.Nodes))
+		distance := 0.0
+		for j, node := range path.Nodes {
+			coord, ok := nodes.Coord(node)
+			if !ok {
+				return []geotypes.CoordinatePath{}, fmt.Errorf("node %d has no coordinates", node)
+			}
+			coords[j] = coord
+			if j > 0 {
+				distance += geodb.Distance(coords[j-1], coord)
+			}
+		}
+
+		coordPaths[i] = geotypes.CoordinatePath{
+			Distance: int(distance + 0.5),
+			Time:     v.Seconds(path.Length),
+			Coords:   coords,
+		}
+	}
+
+	return coordPaths, nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SpeedProfile names the graphs preprocessed for a kind of vehicle, one
+// <country>-<profile>.sgr file per country. The original profiles are top
+// speeds in km/h, like "100", and are written as JSON numbers; requests may
+// send them either way.
+type SpeedProfile string
+
+func (p SpeedProfile) MarshalJSON() ([]byte, error) {
+	if n, err := strconv.Atoi(string(p)); err == nil && strconv.Itoa(n) == string(p) {
+		return []byte(p), nil
+	}
+	return json.Marshal(string(p))
+}
+
+func (p *SpeedProfile) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*p = SpeedProfile(name)
+		return nil
+	}
+	var speed float64
+	if err := json.Unmarshal(data, &speed); err != nil {
+		return err
+	}
+	*p = SpeedProfile(strconv.Itoa(int(speed)))
+	return nil
+}
+
+// Profile describes a speed profile.
+type Profile struct {
+	Name        SpeedProfile      `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	// The allowed countries that have a graph file for the profile.
+	Countries []string `json:"countries"`
+}
+
+// Least time between the scans for unknown speed profiles, see Get.
+const profileRescanInterval = 10 * time.Second
+
+// ProfileRegistry knows the speed profiles of the allowed countries. Without
+// declared profiles, every profile with a graph file in the data directory is
+// available; with them, only the declared ones are.
+type ProfileRegistry struct {
+	dataDir   string
+	countries map[string]bool
+	declared  []Profile
+	now       func() time.Time
+
+	mu       sync.RWMutex
+	profiles map[SpeedProfile]*Profile
+	scanned  time.Time // when the data directory was last read
+
+	// The profiles found at start-up, see Required.
+	required []Profile
+}
+
+func NewProfileRegistry(dataDir string, countries map[string]bool, declared []Profile) *ProfileRegistry {
+	r := &ProfileRegistry{dataDir: dataDir, countries: countries, declared: declared, now: time.Now}
+	if err := r.Scan(); err != nil {
+		log.Printf("reading speed profiles failed: %s", err.Error())
+	}
+	r.required = r.List()
+	return r
+}
+
+// Scan looks up the graph files in the data directory again.
+func (r *ProfileRegistry) Scan() error {
+	profiles := make(map[SpeedProfile]*Profile)
+	for _, p := range r.declared {
+		p := p
+		p.Countries = []string{}
+		profiles[p.Name] = &p
+	}
+
+	files, err := ioutil.ReadDir(r.dataDir)
+	for _, f := range files {
+		country, name, ok := r.parseGraphFile(f.Name())
+		if !ok {
+			continue
+		}
+		p, ok := profiles[name]
+		if !ok {
+			if len(r.declared) > 0 {
+				continue
+			}
+			p = &Profile{Name: name, Countries: []string{}}
+			profiles[name] = p
+		}
+		p.Countries = append(p.Countries, country)
+	}
+	for _, p := range profiles {
+		sort.Strings(p.Countries)
+	}
+
+	r.mu.Lock()
+	r.profiles, r.scanned = profiles, r.now()
+	r.mu.Unlock()
+	return err
+}
+
+// Splits a graph file name of an allowed country into the country and the
+// speed profile, see GraphFile.
+func (r *ProfileRegistry) parseGraphFile(file string) (string, SpeedProfile, bool) {
+	if !strings.HasSuffix(file, ".sgr") {
+		return "", "", false
+	}
+	// The longest country wins, in case one country name starts another.
+	base := strings.TrimSuffix(file, ".sgr")
+	match := ""
+	for country := range r.countries {
+		if strings.HasPrefix(base, country+"-") && len(base) > len(country)+1 && len(country) > len(match) {
+			match = country
+		}
+	}
+	if match == "" {
+		return "", "", false
+	}
+	return match, SpeedProfile(base[len(match)+1:]), true
+}
+
+// Get returns the profile with the given name. Unknown names are looked up
+// in the data directory again, so that graph files added since are found,
+// but at most once every profileRescanInterval, so that requests for bogus
+// profiles don't read the directory every time.
+func (r *ProfileRegistry) Get(name SpeedProfile) (Profile, bool) {
+	r.mu.RLock()
+	p, ok := r.profiles[name]
+	r.mu.RUnlock()
+	if ok {
+		return *p, true
+	}
+
+	r.mu.Lock()
+	stale := r.now().Sub(r.scanned) >= profileRescanInterval
+	if stale {
+		// Claim the scan, so that concurrent misses don't scan too.
+		r.scanned = r.now()
+	}
+	r.mu.Unlock()
+	if !stale {
+		return Profile{}, false
+	}
+	if err := r.Scan(); err != nil {
+		log.Printf("reading speed profiles failed: %s", err.Error())
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if p, ok := r.profiles[name]; ok {
+		return *p, true
+	}
+	return Profile{}, false
+}
+
+// List returns the profiles sorted by name.
+func (r *ProfileRegistry) List() []Profile {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	profiles := make([]Profile, 0, len(r.profiles))
+	for _, p := range r.profiles {
+		profiles = append(profiles, *p)
+	}
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].Name < profiles[j].Name
+	})
+	return profiles
+}
+
+// Required returns the profiles found when the registry was created, with
+// the countries they had graph files for then. Those graphs must stay
+// available, whatever later scans find.
+func (r *ProfileRegistry) Required() []Profile {
+	return r.required
+}
+
+// Names returns the names of the profiles, sorted.
+func (r *ProfileRegistry) Names() []SpeedProfile {
+	var names []SpeedProfile
+	for _, p := range r.List() {
+		names = append(names, p.Name)
+	}
+	return names
+}
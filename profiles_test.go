@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSpeedProfileJSON(t *testing.T) {
+	var input struct{ A, B, C SpeedProfile }
+	if err := json.Unmarshal([]byte(`{"A": 100, "B": "100", "C": "truck-heavy"}`), &input); err != nil {
+		t.Fatal(err)
+	}
+	if input.A != "100" || input.B != "100" || input.C != "truck-heavy" {
+		t.Errorf("decoded %+v", input)
+	}
+
+	out, err := json.Marshal(input)
+	if want := `{"A":100,"B":100,"C":"truck-heavy"}`; err != nil || string(out) != want {
+		t.Errorf("encoded %s, %v, want %s", out, err, want)
+	}
+}
+
+func writeGraphFiles(t *testing.T, dir string, names ...string) {
+	for _, name := range names {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestProfileRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "via-profiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeGraphFiles(t, dir, "finland-100.sgr", "finland-truck-heavy.sgr", "germany-100.sgr",
+		"guinea-bissau-van.sgr", "france-100.sgr", "finland.coords", "finland-100.sgr.bak")
+
+	countries := map[string]bool{"finland": true, "germany": true, "guinea": true, "guinea-bissau": true}
+	r := NewProfileRegistry(dir, countries, nil)
+
+	want := []Profile{
+		{Name: "100", Countries: []string{"finland", "germany"}},
+		{Name: "truck-heavy", Countries: []string{"finland"}},
+		{Name: "van", Countries: []string{"guinea-bissau"}},
+	}
+	if got := r.List(); !reflect.DeepEqual(got, want) {
+		t.Errorf("discovered %+v, want %+v", got, want)
+	}
+
+	now := time.Now()
+	r.now = func() time.Time { return now }
+	now = now.Add(profileRescanInterval)
+	if _, ok := r.Get("60"); ok {
+		t.Error("found a profile without graph files")
+	}
+	writeGraphFiles(t, dir, "germany-60.sgr")
+	if _, ok := r.Get("60"); ok {
+		t.Error("scanned again right after the last scan")
+	}
+	now = now.Add(profileRescanInterval)
+	if p, ok := r.Get("60"); !ok || !reflect.DeepEqual(p.Countries, []string{"germany"}) {
+		t.Errorf("graph file added later => %+v, %v", p, ok)
+	}
+
+	declared := []Profile{
+		{Name: "truck-heavy", Description: "trucks over 12 t", Metadata: map[string]string{"vehicle": "truck"}},
+		{Name: "bike"},
+	}
+	r = NewProfileRegistry(dir, countries, declared)
+	want = []Profile{
+		{Name: "bike", Countries: []string{}},
+		{Name: "truck-heavy", Description: "trucks over 12 t", Metadata: map[string]string{"vehicle": "truck"}, Countries: []string{"finland"}},
+	}
+	if got := r.List(); !reflect.DeepEqual(got, want) {
+		t.Errorf("declared %+v, want %+v", got, want)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
@@ -9,26 +10,43 @@ import (
 	"os/signal"
 	"runtime"
 	"syscall"
+	"time"
 
-	"github.com/hoisie/web"
-
-	// register this postgres driver with the SQL module
 	_ "net/http/pprof"
 
-	_ "github.com/lib/pq"
+	"github.com/hoisie/web"
+	"github.com/nfleet/via/geodb"
+	"github.com/nfleet/via/geotypes"
 )
 
 type (
 	Server struct {
 		Via              *Via
+		Geo              geotypes.GeoDB
 		AllowedCountries map[string]bool
 		Host             string
 		Port             int
+
+		// Largest matrix, in cells, and most paths computed per request.
+		MaxMatrixCells int
+		MaxPaths       int
+
+		// API keys; nil if requests need no key.
+		Keys *Keyring
+
+		Profiles *ProfileRegistry
+
+		// The area the coordinates of each country must fall in.
+		BoundingBoxes map[string]BoundingBox
+
+		Metrics *Metrics
 	}
 )
 
 const (
 	expiry int = 3600
+
+	defaultShutdownTimeout = 30 * time.Second
 )
 
 var (
@@ -81,39 +99,136 @@ func main() {
 		return
 	}
 
-	// Handle SIGINT and SIGKILL
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, os.Kill, syscall.SIGABRT)
-	go func() {
-		for sig := range c {
-			log.Printf("received %v, exiting...", sig)
-			os.Exit(1)
-		}
-	}()
+	// Shut down gracefully on SIGINT and SIGTERM, see shutdown.
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	boundingBoxes, err := LoadBoundingBoxes(config.DataDir, config.BoundingBoxes)
+	if err != nil {
+		log.Printf("reading bounding boxes failed: %s", err.Error())
+		return
+	}
 
 	procs := runtime.NumCPU()
 	runtime.GOMAXPROCS(procs)
 
 	log.Printf("starting server, running on %d cores...", procs)
 
-	via := NewVia(Debug, expiry, config.DataDir)
-	server := Server{Via: via, Host: config.Host, Port: config.Port, AllowedCountries: config.AllowedCountries}
+	via := NewVia(Debug, Parallel, expiry, config.DataDir)
+	if config.WeightSeconds > 0 {
+		via.WeightSeconds = config.WeightSeconds
+	}
+	if config.RedisAddr != "" {
+		log.Printf("caching results in redis at %s", config.RedisAddr)
+		via.Cache = NewRedisCache(config.RedisAddr, config.RedisPass)
+	} else if config.CacheMegabytes > 0 {
+		via.Cache = NewLRUCache(config.CacheMegabytes << 20)
+	}
+	geo := geodb.NewDB(via.Nodes, config.AllowedCountries)
+	server := Server{Via: via, Geo: geo, Host: config.Host, Port: config.Port, AllowedCountries: config.AllowedCountries,
+		MaxMatrixCells: config.MaxMatrixCells, MaxPaths: config.MaxPaths, BoundingBoxes: boundingBoxes, Metrics: NewMetrics()}
+	if len(config.APIKeys) > 0 {
+		server.Keys = NewKeyring(config.APIKeys)
+	}
+	server.Profiles = NewProfileRegistry(config.DataDir, config.AllowedCountries, config.SpeedProfiles)
+	log.Printf("speed profiles: %v", server.Profiles.Names())
+
+	if config.PreloadGraphs {
+		log.Print("preloading graphs...")
+		via.Graphs.Preload(server.Profiles.List())
+	}
+
+	ws := web.NewServer()
 
 	// Basic
-	web.Get("/", Splash)
-	web.Get("/status", server.GetServerStatus)
+	ws.Get("/", Splash)
+	ws.Get("/status", server.GetServerStatus)
+	ws.Get("/health/ready", server.GetServerStatus)
+	ws.Get("/health/live", GetLiveness)
+	ws.Get("/metrics", server.GetMetrics)
+	ws.Get("/profiles", server.GetProfiles)
 
 	// Dmatrix
-	web.Post("/matrix/", server.PostMatrix)
+	ws.Post("/matrix/", server.PostMatrix)
+	ws.Get("/matrix/([^/]+)/result", server.GetMatrixResult)
+	ws.Post("/matrix/([^/]+)/update", server.PostMatrixUpdate)
+	ws.Get("/matrix/([^/]+)", server.GetMatrix)
 
 	// Path
-	web.Post("/paths", server.PostPaths)
+	ws.Post("/paths", server.PostPaths)
 
-	web.Match("OPTIONS", "/(.*)", Options)
+	// Geocoding
+	ws.Post("/resolve", server.PostResolve)
+
+	ws.Match("OPTIONS", "/(.*)", Options)
 
 	go func() {
 		log.Println(http.ListenAndServe("localhost:6060", nil))
 	}()
 
-	web.Run(fmt.Sprintf("%s:%d", config.Host, config.Port))
+	httpServer := &http.Server{Addr: fmt.Sprintf("%s:%d", config.Host, config.Port), Handler: server.Metrics.Instrument(ws)}
+	serve := httpServer.ListenAndServe
+
+	switch config.SslMode {
+	case "", SslDisable:
+	case SslEnable, SslVerifyClient:
+		verifyClients := config.SslMode == SslVerifyClient
+		if verifyClients && config.ClientCAFile == "" {
+			log.Fatalf("SslMode %s needs a ClientCAFile", config.SslMode)
+		}
+		certs, err := newCertStore(config.CertFile, config.KeyFile, config.ClientCAFile)
+		if err != nil {
+			log.Fatalf("loading certificates failed: %s", err.Error())
+		}
+		httpServer.TLSConfig = certs.TLSConfig(verifyClients)
+		serve = func() error { return httpServer.ListenAndServeTLS("", "") }
+
+		// Reload the certificates on SIGHUP
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				if err := certs.Reload(); err != nil {
+					log.Printf("reloading certificates failed, keeping the old ones: %s", err.Error())
+					continue
+				}
+				log.Print("reloaded certificates")
+			}
+		}()
+	default:
+		log.Fatalf("unknown SslMode %q, must be %s, %s or %s", config.SslMode, SslDisable, SslEnable, SslVerifyClient)
+	}
+
+	go func() {
+		log.Printf("listening on %s", httpServer.Addr)
+		if err := serve(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	sig := <-stop
+	timeout := time.Duration(config.ShutdownSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	log.Printf("received %v, shutting down within %s...", sig, timeout)
+	if !shutdown(httpServer, via, time.Now().Add(timeout)) {
+		log.Print("computations still running at the deadline, exiting anyway")
+		os.Exit(1)
+	}
+	log.Print("shut down cleanly")
+}
+
+// Stops accepting requests and waits until the requests being served and the
+// asynchronous matrix jobs finish or the deadline passes. If everything
+// finished in time, the graphs are freed and shutdown returns true.
+func shutdown(httpServer *http.Server, via *Via, deadline time.Time) bool {
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	if err := httpServer.Shutdown(ctx); err != nil {
+		log.Printf("requests still running: %s", err.Error())
+		return false
+	}
+	return via.Shutdown(deadline)
 }
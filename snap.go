@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/nfleet/via/geotypes"
+)
+
+// NodeList is a list of graph nodes, given either as node IDs or as
+// [latitude, longitude] pairs that get snapped to their nearest node.
+type NodeList struct {
+	IDs    []int
+	Coords []geotypes.Coord
+}
+
+func (l *NodeList) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &l.IDs); err == nil {
+		return nil
+	}
+	l.IDs = nil
+
+	if err := json.Unmarshal(data, &l.Coords); err != nil {
+		return fmt.Errorf("nodes must be a list of node IDs or of [latitude, longitude] pairs")
+	}
+	for i, c := range l.Coords {
+		if len(c) != 2 {
+			return fmt.Errorf("coordinate %d is not a [latitude, longitude] pair", i)
+		}
+	}
+	return nil
+}
+
+// Len returns the number of nodes in the list.
+func (l NodeList) Len() int {
+	if l.Coords != nil {
+		return len(l.Coords)
+	}
+	return len(l.IDs)
+}
+
+// SnappedNode tells which graph node a coordinate was snapped to.
+type SnappedNode struct {
+	Node     int            `json:"node"`
+	Coord    geotypes.Coord `json:"coord"`
+	Distance float64        `json:"distance"` // meters from the given coordinate
+}
+
+// Returns the node IDs of the list. Coordinates are snapped to the nearest node
+// of country; in that case the snapped nodes are returned as well.
+func (v *Via) ResolveNodes(list NodeList, country string) ([]int, []SnappedNode, error) {
+	if list.Coords == nil {
+		return list.IDs, nil, nil
+	}
+
+	nodes, err := v.Nodes.Nodes(country)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ids := make([]int, len(list.Coords))
+	snapped := make([]SnappedNode, len(list.Coords))
+	for i, c := range list.Coords {
+		node, dist, err := nodes.Nearest(c)
+		if err != nil {
+			return nil, nil, err
+		}
+		ids[i] = node.Id
+		snapped[i] = SnappedNode{Node: node.Id, Coord: node.Coord, Distance: dist}
+	}
+
+	return ids, snapped, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestNodeListUnmarshal(t *testing.T) {
+	var tests = []struct {
+		in     string
+		ids    int
+		coords int
+		err    bool
+	}{
+		{`[1, 2, 3]`, 3, 0, false},
+		{`[[60.17, 24.94], [61.50, 23.76]]`, 0, 2, false},
+		{`[]`, 0, 0, false},
+		{`[[60.17]]`, 0, 0, true},
+		{`["helsinki"]`, 0, 0, true},
+	}
+
+	for i, test := range tests {
+		var l NodeList
+		err := json.Unmarshal([]byte(test.in), &l)
+		if (err != nil) != test.err {
+			t.Errorf("%d. Unmarshal(%s) error => %v, want error %v", i, test.in, err, test.err)
+			continue
+		}
+		if !test.err && (len(l.IDs) != test.ids || len(l.Coords) != test.coords) {
+			t.Errorf("%d. Unmarshal(%s) => %d ids, %d coords, want %d, %d", i, test.in, len(l.IDs), len(l.Coords), test.ids, test.coords)
+		}
+	}
+}
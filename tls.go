@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"sync"
+)
+
+// SslMode values of the config.
+const (
+	SslDisable      = "disable"       // plain HTTP, the default
+	SslEnable       = "enable"        // HTTPS with CertFile and KeyFile
+	SslVerifyClient = "verify-client" // HTTPS, clients need a certificate signed by ClientCAFile
+)
+
+// certStore holds the server certificate and the client CAs served over TLS.
+// Reload reads them again from their files, so that renewed certificates are
+// used without a restart.
+type certStore struct {
+	certFile, keyFile, clientCAFile string
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+// Loads the certificate and key, and the client CAs if clientCAFile is set.
+func newCertStore(certFile, keyFile, clientCAFile string) (*certStore, error) {
+	s := &certStore{certFile: certFile, keyFile: keyFile, clientCAFile: clientCAFile}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload reads the certificate files again. On error the previous
+// certificates stay in use.
+func (s *certStore) Reload() error {
+	cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		return err
+	}
+
+	var clientCAs *x509.CertPool
+	if s.clientCAFile != "" {
+		pem, err := ioutil.ReadFile(s.clientCAFile)
+		if err != nil {
+			return err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates in %s", s.clientCAFile)
+		}
+	}
+
+	s.mu.Lock()
+	s.cert, s.clientCAs = &cert, clientCAs
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *certStore) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cert, nil
+}
+
+// TLSConfig returns a server config that always uses the current
+// certificates. With verifyClients set, clients must present a certificate
+// signed by one of the client CAs.
+func (s *certStore) TLSConfig(verifyClients bool) *tls.Config {
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: s.getCertificate,
+		// Set here rather than left to http.Server, so that the configs
+		// returned for verified clients offer HTTP/2 too.
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+	if verifyClients {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		base := config.Clone()
+		config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			s.mu.RLock()
+			defer s.mu.RUnlock()
+			client := base.Clone()
+			client.ClientCAs = s.clientCAs
+			return client, nil
+		}
+	}
+	return config
+}
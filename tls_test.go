@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Writes a self-signed certificate for name and its key to dir.
+func writeTestCert(t *testing.T, dir, name string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile = filepath.Join(dir, "via.crt"), filepath.Join(dir, "via.key")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func servedName(t *testing.T, s *certStore) string {
+	cert, _ := s.getCertificate(nil)
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.Subject.CommonName
+}
+
+func TestCertReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "via-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeTestCert(t, dir, "old")
+	store, err := newCertStore(certFile, keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestCert(t, dir, "new")
+	if err := store.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if name := servedName(t, store); name != "new" {
+		t.Errorf("reloaded certificate is for %q, want new", name)
+	}
+
+	os.Remove(keyFile)
+	if err := store.Reload(); err == nil {
+		t.Error("reload without a key should fail")
+	}
+	if name := servedName(t, store); name != "new" {
+		t.Errorf("failed reload replaced the certificate with one for %q", name)
+	}
+}
+
+func TestClientCAs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "via-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeTestCert(t, dir, "ca")
+	store, err := newCertStore(certFile, keyFile, certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := store.TLSConfig(true).GetConfigForClient(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ClientCAs == nil || len(config.ClientCAs.Subjects()) != 1 {
+		t.Errorf("client config should trust the one client CA")
+	}
+	if config.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Errorf("client config doesn't verify clients")
+	}
+	if !reflect.DeepEqual(config.NextProtos, []string{"h2", "http/1.1"}) {
+		t.Errorf("client config offers %v, want HTTP/2 and HTTP/1.1", config.NextProtos)
+	}
+}
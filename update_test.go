@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtendMatrix(t *testing.T) {
+	weight := func(s, t int) uint32 { return uint32(s*100 + t) }
+	full := func(sources, targets []int) Matrix {
+		m := Matrix{Rows: len(sources), Cols: len(targets)}
+		for _, s := range sources {
+			for _, t := range targets {
+				m.Weights = append(m.Weights, weight(s, t))
+			}
+		}
+		return m
+	}
+
+	prev := full([]int{1, 2, 3}, []int{1, 2, 3})
+	computed := 0
+	compute := func(sources, targets []int) (Matrix, error) {
+		computed += len(sources) * len(targets)
+		return full(sources, targets), nil
+	}
+
+	// Drop node 2 and add nodes 4 and 5.
+	sources, targets := []int{1, 3, 4, 5}, []int{1, 3, 4, 5}
+	got, err := extendMatrix(prev, []int{0, 2}, []int{0, 2}, sources, targets, compute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := full(sources, targets); !reflect.DeepEqual(got, want) {
+		t.Errorf("extended matrix => %v, want %v", got, want)
+	}
+	if computed != 12 {
+		t.Errorf("computed %d cells, want the 12 new ones", computed)
+	}
+
+	// Only remove.
+	computed = 0
+	got, err = extendMatrix(prev, []int{1}, []int{0, 2}, []int{2}, []int{1, 3}, compute)
+	if want := full([]int{2}, []int{1, 3}); err != nil || !reflect.DeepEqual(got, want) || computed != 0 {
+		t.Errorf("shrunk matrix => %v, %v after %d cells, want %v", got, err, computed, want)
+	}
+}
+
+func TestKeptIndices(t *testing.T) {
+	kept, e := keptIndices("sources", 5, []int{1}, []int{3, 1})
+	if e != nil || !reflect.DeepEqual(kept, []int{0, 2, 4}) {
+		t.Errorf("keptIndices => %v, %v", kept, e)
+	}
+
+	_, e = keptIndices("targets", 3, nil, []int{0, 3, -1})
+	if e == nil || !reflect.DeepEqual(e.Indices, []int{1, 2}) {
+		t.Errorf("keptIndices of out of range indices => %v", e)
+	}
+}
@@ -2,23 +2,76 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"io/ioutil"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nfleet/via/geodb"
 )
 
 type Via struct {
 	Debug   Debugging
 	Expiry  int
 	DataDir string
+	Graphs  *GraphRegistry
+	Nodes   *geodb.Store
+	Jobs    *JobStore
+	// Computed matrices and paths, kept for Expiry seconds.
+	Cache Cache
+
+	// Compute matrices in parallel unless a request says otherwise.
+	Parallel bool
+
+	// Graph edge weights are travel times; this many seconds make one unit.
+	WeightSeconds float64
+
+	// Limits how many asynchronous jobs compute at the same time.
+	jobSlots chan struct{}
+
+	// A token per core, taken for every thread the CH library computes with,
+	// so that concurrent computations don't run more threads than there are
+	// cores; see takeThreads.
+	threads chan struct{}
+
+	// Tracks the running computations, so that Shutdown can wait for them.
+	busy    sync.WaitGroup
+	running int64 // computations in progress, for the metrics
+	mu      sync.Mutex
+	closing bool
 }
 
 type ViaConfig struct {
 	Host             string
 	Port             int
-	SslMode          string
+	SslMode          string // see the Ssl constants
+	CertFile         string
+	KeyFile          string
+	ClientCAFile     string
 	DataDir          string
-	RedisAddr        string
+	RedisAddr        string // caches results in Redis if set, else in memory
 	RedisPass        string
+	CacheMegabytes   int // size of the in-memory cache
 	AllowedCountries map[string]bool
+	PreloadGraphs    bool
+	WeightSeconds    float64
+	BoundingBoxes    map[string]BoundingBox
+
+	// Request size limits, zero means unlimited.
+	MaxMatrixCells int
+	MaxPaths       int
+
+	// How long to wait for running computations when shutting down.
+	ShutdownSeconds int
+
+	// API keys by key. Without keys, requests need no key.
+	APIKeys map[string]APIKey
+
+	// Speed profiles, with graph files <country>-<name>.sgr in DataDir. If
+	// none are declared, every profile with graph files is used.
+	SpeedProfiles []Profile
 }
 
 func LoadConfig(file string) (ViaConfig, error) {
@@ -34,10 +87,98 @@ func LoadConfig(file string) (ViaConfig, error) {
 	return config, nil
 }
 
-func NewVia(debug bool, expiry int, dataDir string) *Via {
+// Converts a graph weight to seconds.
+func (v *Via) Seconds(weight int) int {
+	return int(float64(weight)*v.WeightSeconds + 0.5)
+}
+
+// errShuttingDown is returned by computations started after Shutdown.
+var errShuttingDown = errors.New("server is shutting down")
+
+// Marks the start of a computation, which must be ended with leave. Fails
+// once Shutdown has been called.
+func (v *Via) enter() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.closing {
+		return errShuttingDown
+	}
+	v.busy.Add(1)
+	atomic.AddInt64(&v.running, 1)
+	return nil
+}
+
+func (v *Via) leave() {
+	atomic.AddInt64(&v.running, -1)
+	v.busy.Done()
+}
+
+// Running returns the number of computations in progress.
+func (v *Via) Running() int64 {
+	return atomic.LoadInt64(&v.running)
+}
+
+// Takes the threads a computation runs on, waiting for the first one: just
+// one if parallel isn't set, else every free one. Returns how many it took,
+// which must be given back with releaseThreads.
+func (v *Via) takeThreads(parallel bool) int {
+	v.threads <- struct{}{}
+	n := 1
+	for parallel && n < cap(v.threads) {
+		select {
+		case v.threads <- struct{}{}:
+			n++
+		default:
+			return n
+		}
+	}
+	return n
+}
+
+func (v *Via) releaseThreads(n int) {
+	for i := 0; i < n; i++ {
+		<-v.threads
+	}
+}
+
+// Shutdown refuses new computations and waits until the running ones finish
+// or the deadline passes. If they all finished, the graphs are freed and
+// Shutdown returns true.
+func (v *Via) Shutdown(deadline time.Time) bool {
+	v.mu.Lock()
+	v.closing = true
+	v.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		v.busy.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		v.Graphs.Close()
+		return true
+	case <-time.After(time.Until(deadline)):
+		return false
+	}
+}
+
+func NewVia(debug, parallel bool, expiry int, dataDir string) *Via {
 	return &Via{
 		Debug:   Debugging(debug),
 		Expiry:  expiry,
 		DataDir: dataDir,
+		Graphs:  NewGraphRegistry(dataDir),
+		Nodes:   geodb.NewStore(dataDir),
+		Jobs:    NewJobStore(time.Duration(expiry) * time.Second),
+		Cache:   NewLRUCache(defaultCacheMegabytes << 20),
+
+		Parallel:      parallel,
+		WeightSeconds: 1,
+
+		jobSlots: make(chan struct{}, runtime.NumCPU()),
+		threads:  make(chan struct{}, runtime.NumCPU()),
 	}
 }
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShutdownWaitsForComputations(t *testing.T) {
+	v := NewVia(false, false, 60, "")
+
+	if err := v.enter(); err != nil {
+		t.Fatal(err)
+	}
+	if v.Shutdown(time.Now().Add(10 * time.Millisecond)) {
+		t.Fatal("shutdown finished while a computation was running")
+	}
+	if err := v.enter(); err != errShuttingDown {
+		t.Errorf("computation started during shutdown => %v, want %v", err, errShuttingDown)
+	}
+
+	v.leave()
+	if !v.Shutdown(time.Now().Add(time.Second)) {
+		t.Error("shutdown timed out without running computations")
+	}
+}
+
+func TestTakeThreads(t *testing.T) {
+	const cores = 4
+	v := NewVia(false, false, 60, "")
+	v.threads = make(chan struct{}, cores)
+
+	if n := v.takeThreads(false); n != 1 {
+		t.Errorf("sequential computation took %d threads, want 1", n)
+	}
+	if n := v.takeThreads(true); n != cores-1 {
+		t.Errorf("parallel computation took %d threads, want the %d free", n, cores-1)
+	}
+
+	taken := make(chan int)
+	go func() { taken <- v.takeThreads(true) }()
+	select {
+	case n := <-taken:
+		t.Fatalf("took %d threads while all were in use", n)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	v.releaseThreads(1)
+	if n := <-taken; n != 1 {
+		t.Errorf("took %d threads after one was released, want 1", n)
+	}
+}